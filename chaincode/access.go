@@ -0,0 +1,177 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ATTR_ROLE     string = "viriot.role"
+	ROLE_ADMIN    string = "admin"
+	ROLE_PROVIDER string = "provider"
+	ROLE_CONSUMER string = "consumer"
+)
+
+// functionPolicies lists the roles allowed to submit each provider-side, consumer-side
+// or organization-level transaction.
+// Transactions without an entry are open to every member of the channel.
+var functionPolicies = map[string][]string{
+	"CreateThingVisor":            {ROLE_PROVIDER, ROLE_ADMIN},
+	"UpdateThingVisor":            {ROLE_PROVIDER, ROLE_ADMIN},
+	"UpdateThingVisorPartial":     {ROLE_PROVIDER, ROLE_ADMIN},
+	"PatchThingVisor":             {ROLE_PROVIDER, ROLE_ADMIN},
+	"DeleteThingVisor":            {ROLE_PROVIDER, ROLE_ADMIN},
+	"RunThingVisor":               {ROLE_PROVIDER, ROLE_ADMIN},
+	"StopThingVisor":              {ROLE_PROVIDER, ROLE_ADMIN},
+	"MarkThingVisorStopped":       {ROLE_PROVIDER, ROLE_ADMIN},
+	"FailThingVisor":              {ROLE_PROVIDER, ROLE_ADMIN},
+	"AddVThingToThingVisor":       {ROLE_PROVIDER, ROLE_ADMIN},
+	"UpdateVThingOfThingVisor":    {ROLE_PROVIDER, ROLE_ADMIN},
+	"PatchVThing":                 {ROLE_PROVIDER, ROLE_ADMIN},
+	"DeleteVThingFromThingVisor":  {ROLE_PROVIDER, ROLE_ADMIN},
+	"AddVThingsToThingVisor":      {ROLE_PROVIDER, ROLE_ADMIN},
+	"DeleteVThingsFromThingVisor": {ROLE_PROVIDER, ROLE_ADMIN},
+	"AddFlavour":                  {ROLE_PROVIDER, ROLE_ADMIN},
+	"UpdateFlavour":               {ROLE_PROVIDER, ROLE_ADMIN},
+	"PatchFlavour":                {ROLE_PROVIDER, ROLE_ADMIN},
+	"DeleteFlavour":               {ROLE_PROVIDER, ROLE_ADMIN},
+	"MarkFlavourReady":            {ROLE_PROVIDER, ROLE_ADMIN},
+	"FailFlavour":                 {ROLE_PROVIDER, ROLE_ADMIN},
+	"ProposeDataSharingAgreement": {ROLE_ADMIN},
+	"AcceptDataSharingAgreement":  {ROLE_ADMIN},
+	"RevokeDataSharingAgreement":  {ROLE_ADMIN},
+	"ReportUsage":                 {ROLE_PROVIDER, ROLE_ADMIN},
+	"SetTenantQuota":              {ROLE_ADMIN},
+	"DeleteTenantQuota":           {ROLE_ADMIN},
+	"RecountTenantUsage":          {ROLE_ADMIN},
+	"PublishOffer":                {ROLE_PROVIDER, ROLE_ADMIN},
+	"WithdrawOffer":               {ROLE_PROVIDER, ROLE_ADMIN},
+	"ApproveSubscriptionRequest":  {ROLE_PROVIDER, ROLE_ADMIN},
+	"RejectSubscriptionRequest":   {ROLE_PROVIDER, ROLE_ADMIN},
+	"SweepExpiredBindings":        {ROLE_ADMIN},
+	"AddVirtualSilo":              {ROLE_CONSUMER, ROLE_ADMIN},
+	"UpdateVirtualSilo":           {ROLE_CONSUMER, ROLE_ADMIN},
+	"PatchVirtualSilo":            {ROLE_CONSUMER, ROLE_ADMIN},
+	"DeleteVirtualSilo":           {ROLE_CONSUMER, ROLE_ADMIN},
+	"RunVirtualSilo":              {ROLE_CONSUMER, ROLE_ADMIN},
+	"StopVirtualSilo":             {ROLE_CONSUMER, ROLE_ADMIN},
+	"MarkVirtualSiloStopped":      {ROLE_CONSUMER, ROLE_ADMIN},
+	"FailVirtualSilo":             {ROLE_CONSUMER, ROLE_ADMIN},
+	"AddVThingVSilo":              {ROLE_CONSUMER, ROLE_ADMIN},
+	"AddVThingVSiloBatch":         {ROLE_CONSUMER, ROLE_ADMIN},
+	"DeleteVThingVSilo":           {ROLE_CONSUMER, ROLE_ADMIN},
+	"SubmitSubscriptionRequest":   {ROLE_CONSUMER, ROLE_ADMIN},
+}
+
+// getRole returns the viriot.role attribute of the certificate of the caller
+func getRole(ctx contractapi.TransactionContextInterface) string {
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue(ATTR_ROLE)
+	if err != nil || !found {
+		return ""
+	}
+	return role
+}
+
+// callerGraph links the caller to its organization on the side of its role, as a
+// provider user or a consumer tenant. Admins act on both sides and are linked on
+// adminSide, ROLE_PROVIDER or ROLE_CONSUMER, the side of the asset they manage.
+func callerGraph(ctx contractapi.TransactionContextInterface, userID string, userMSPID string, adminSide string) []LogGraph {
+	side := getRole(ctx)
+	if side != ROLE_PROVIDER && side != ROLE_CONSUMER {
+		side = adminSide
+	}
+	if side == ROLE_CONSUMER {
+		return []LogGraph{
+			{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+			{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+		}
+	}
+	return []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+	}
+}
+
+// checkFunctionPolicy runs before every transaction and rejects callers whose
+// role is not listed for the invoked function in functionPolicies
+func checkFunctionPolicy(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+	if function == "" {
+		return nil
+	}
+	runes := []rune(function)
+	runes[0] = unicode.ToUpper(runes[0])
+	function = string(runes)
+	roles, ok := functionPolicies[function]
+	if !ok {
+		return nil
+	}
+	role := getRole(ctx)
+	for _, allowed := range roles {
+		if role == allowed {
+			return nil
+		}
+	}
+	return forbidden("", "", function+" requires one of the roles "+strings.Join(roles, ", ")+" in attribute "+ATTR_ROLE)
+}
+
+// isAdmin reports whether the certificate of the caller carries the admin role attribute
+func isAdmin(ctx contractapi.TransactionContextInterface) bool {
+	return getRole(ctx) == ROLE_ADMIN
+}
+
+// callerTenantID returns the enrollment ID of the caller, the common name of its
+// certificate, which the master-controller uses as the tenant ID of its users
+func callerTenantID(ctx contractapi.TransactionContextInterface) (string, error) {
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return "", internalError("", "", err)
+	}
+	if cert == nil {
+		return "", nil
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// checkTenant only lets a caller act as the tenant of its own enrollment ID, and so
+// spend or read its own quota, unless the caller is an admin
+func checkTenant(ctx contractapi.TransactionContextInterface, kind string, id string, tenantID string) error {
+	if isAdmin(ctx) {
+		return nil
+	}
+	callerID, err := callerTenantID(ctx)
+	if err != nil {
+		return err
+	}
+	if callerID == "" || callerID != tenantID {
+		return forbidden(kind, id, "tenant "+tenantID+" does not match the identity of the caller")
+	}
+	return nil
+}
+
+// canAccessSilo reports whether the caller owns the silo or is an admin
+func canAccessSilo(ctx contractapi.TransactionContextInterface, owner string) bool {
+	if isAdmin(ctx) {
+		return true
+	}
+	userID, err := ctx.GetClientIdentity().GetID()
+	return err == nil && owner != "" && owner == userID
+}
+
+// checkSiloAccess only lets the owner of a VirtualSilo, or an admin, read or change
+// the silo and its bindings
+func checkSiloAccess(ctx contractapi.TransactionContextInterface, silo *VirtualSilo) error {
+	if !canAccessSilo(ctx, silo.Owner) {
+		return forbidden(NODE_VSILO, silo.VSiloID, "VirtualSilo "+silo.VSiloID+" is owned by another identity")
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	CollectionAgreements string = "collectionAgreements"
+
+	STATUS_PROPOSED string = "proposed"
+	STATUS_ACTIVE   string = "active"
+	STATUS_REVOKED  string = "revoked"
+
+	NODE_AGREEMENT string = "agreement"
+)
+
+// DataSharingAgreement lets the silos of the consumer organization bind the vThings
+// of the ThingVisors of the provider organization. An empty AllowedVThingTypes
+// allows every type; an empty ValidUntil never expires.
+type DataSharingAgreement struct {
+	AgreementID        string   `json:"agreementID"`
+	ProviderMSPID      string   `json:"providerMSPID"`
+	ConsumerMSPID      string   `json:"consumerMSPID"`
+	AllowedVThingTypes []string `json:"allowedVThingTypes"`
+	ValidUntil         string   `json:"validUntil"`
+	Status             string   `json:"status"`
+	ProposedBy         string   `json:"proposedBy"`
+	ProposedByMSPID    string   `json:"proposedByMSPID"`
+	AcceptedBy         string   `json:"acceptedBy"`
+	RevokedBy          string   `json:"revokedBy"`
+	CreationTime       string   `json:"creationTime"`
+	Version            int      `json:"version"`
+	LastModified       string   `json:"lastModified"`
+	LastModifiedBy     string   `json:"lastModifiedBy"`
+}
+
+func getAgreementState(ctx contractapi.TransactionContextInterface, agreementID string) (*DataSharingAgreement, error) {
+	data, err := ctx.GetStub().GetPrivateData(CollectionAgreements, agreementID)
+	if err != nil {
+		return nil, internalError(NODE_AGREEMENT, agreementID, err)
+	}
+	if data == nil {
+		return nil, notFound(NODE_AGREEMENT, agreementID)
+	}
+	var agreement DataSharingAgreement
+	if err := json.Unmarshal(data, &agreement); err != nil {
+		return nil, internalError(NODE_AGREEMENT, agreementID, err)
+	}
+	return &agreement, nil
+}
+
+func putAgreementState(ctx contractapi.TransactionContextInterface, agreement *DataSharingAgreement) error {
+	if err := setLastModified(ctx, &agreement.LastModified, &agreement.LastModifiedBy); err != nil {
+		return err
+	}
+	agreement.Version++
+	data, err := json.Marshal(agreement)
+	if err != nil {
+		return internalError(NODE_AGREEMENT, agreement.AgreementID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionAgreements, agreement.AgreementID, data); err != nil {
+		return internalError(NODE_AGREEMENT, agreement.AgreementID, err)
+	}
+	return nil
+}
+
+// isParty reports whether the organization of the caller signed up to the agreement
+func (a *DataSharingAgreement) isParty(mspID string) bool {
+	return mspID == a.ProviderMSPID || mspID == a.ConsumerMSPID
+}
+
+// covers reports whether the agreement is active at the time now and allows the type
+func (a *DataSharingAgreement) covers(now time.Time, vThingType string) bool {
+	if a.Status != STATUS_ACTIVE {
+		return false
+	}
+	if a.ValidUntil != "" {
+		validUntil, err := time.Parse(time.RFC3339, a.ValidUntil)
+		if err != nil || !now.Before(validUntil) {
+			return false
+		}
+	}
+	return len(a.AllowedVThingTypes) == 0 || containsString(a.AllowedVThingTypes, vThingType)
+}
+
+// findAgreement returns an agreement between the two organizations covering the
+// vThing type at the time of the transaction, or nil
+func findAgreement(ctx contractapi.TransactionContextInterface, providerMSPID string, consumerMSPID string, vThingType string) (*DataSharingAgreement, error) {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionAgreements, "", "")
+	if err != nil {
+		return nil, internalError(NODE_AGREEMENT, "", err)
+	}
+	var found *DataSharingAgreement
+	for resultsIterator.HasNext() && found == nil {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_AGREEMENT, "", err)
+		}
+		var agreement DataSharingAgreement
+		if err := json.Unmarshal(queryResponse.Value, &agreement); err != nil {
+			return nil, internalError(NODE_AGREEMENT, queryResponse.Key, err)
+		}
+		if agreement.ProviderMSPID == providerMSPID && agreement.ConsumerMSPID == consumerMSPID && agreement.covers(now, vThingType) {
+			found = &agreement
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_AGREEMENT, "", err)
+	}
+	return found, nil
+}
+
+// agreementGraph links the caller, through its organization, and both parties to the agreement
+func agreementGraph(agreement *DataSharingAgreement, userID string, userMSPID string) []LogGraph {
+	orgNode, orgType := userMSPID+"-provider", NODE_ORG_PROVIDER
+	if userMSPID == agreement.ConsumerMSPID {
+		orgNode, orgType = userMSPID+"-consumer", NODE_ORG_CONSUMER
+	}
+	return []LogGraph{
+		{Source: orgNode, Target: "user-" + userID, SourceType: orgType, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: orgNode, SourceType: NODE_USER, TargetType: orgType},
+		{Source: "user-" + userID, Target: "agreement-" + agreement.AgreementID, SourceType: NODE_USER, TargetType: NODE_AGREEMENT},
+		{Source: agreement.ProviderMSPID + "-provider", Target: "agreement-" + agreement.AgreementID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_AGREEMENT},
+		{Source: "agreement-" + agreement.AgreementID, Target: agreement.ConsumerMSPID + "-consumer", SourceType: NODE_AGREEMENT, TargetType: NODE_ORG_CONSUMER},
+	}
+}
+
+// ProposeDataSharingAgreement proposes an agreement between a provider and a consumer
+// organization, one of which must be the organization of the caller.
+// allowedVThingTypes is a JSON array of vThing types, empty for all types, and
+// validUntil an optional RFC 3339 expiry.
+func (s *SmartContract) ProposeDataSharingAgreement(ctx contractapi.TransactionContextInterface, agreementID string, providerMSPID string, consumerMSPID string, allowedVThingTypes string, validUntil string) error {
+	if agreementID == "" {
+		return invalidArgument(NODE_AGREEMENT, agreementID, "agreementID", "agreementID must not be empty")
+	}
+	exists, err := ctx.GetStub().GetPrivateData(CollectionAgreements, agreementID)
+	if err != nil {
+		return internalError(NODE_AGREEMENT, agreementID, err)
+	}
+	if exists != nil {
+		return alreadyExists(NODE_AGREEMENT, agreementID)
+	}
+	if providerMSPID == "" || consumerMSPID == "" || providerMSPID == consumerMSPID {
+		return invalidArgument(NODE_AGREEMENT, agreementID, "consumerMSPID", "providerMSPID and consumerMSPID must be two different organizations")
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	agreement := DataSharingAgreement{
+		AgreementID:        agreementID,
+		ProviderMSPID:      providerMSPID,
+		ConsumerMSPID:      consumerMSPID,
+		AllowedVThingTypes: []string{},
+		ValidUntil:         validUntil,
+		Status:             STATUS_PROPOSED,
+		ProposedBy:         userID,
+		ProposedByMSPID:    userMSPID,
+	}
+	if !agreement.isParty(userMSPID) {
+		return forbidden(NODE_AGREEMENT, agreementID, "organization "+userMSPID+" can only propose agreements it is a party to")
+	}
+	if allowedVThingTypes != "" {
+		if err := decodeStrict(NODE_AGREEMENT, agreementID, allowedVThingTypes, &agreement.AllowedVThingTypes); err != nil {
+			return err
+		}
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if validUntil != "" {
+		expiry, err := time.Parse(time.RFC3339, validUntil)
+		if err != nil {
+			return invalidArgument(NODE_AGREEMENT, agreementID, "validUntil", "validUntil must be an RFC 3339 timestamp")
+		}
+		if !now.Before(expiry) {
+			return invalidArgument(NODE_AGREEMENT, agreementID, "validUntil", "validUntil must be in the future")
+		}
+	}
+	agreement.CreationTime = now.Format(time.RFC3339)
+	if err := putAgreementState(ctx, &agreement); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "ProposeDataSharingAgreement", agreementGraph(&agreement, userID, userMSPID), userID, userMSPID)
+}
+
+// AcceptDataSharingAgreement activates a proposed agreement; only the organization
+// that did not propose it can accept it
+func (s *SmartContract) AcceptDataSharingAgreement(ctx contractapi.TransactionContextInterface, agreementID string) error {
+	agreement, err := getAgreementState(ctx, agreementID)
+	if err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if !agreement.isParty(userMSPID) || userMSPID == agreement.ProposedByMSPID {
+		return forbidden(NODE_AGREEMENT, agreementID, "agreement "+agreementID+" can only be accepted by the organization it was proposed to")
+	}
+	if agreement.Status != STATUS_PROPOSED {
+		return invalidState(NODE_AGREEMENT, agreementID, "agreement "+agreementID+" is "+agreement.Status+", not "+STATUS_PROPOSED)
+	}
+	if agreement.ValidUntil != "" {
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		if validUntil, err := time.Parse(time.RFC3339, agreement.ValidUntil); err != nil || !now.Before(validUntil) {
+			return invalidState(NODE_AGREEMENT, agreementID, "agreement "+agreementID+" has expired")
+		}
+	}
+	agreement.Status = STATUS_ACTIVE
+	agreement.AcceptedBy = userID
+	if err := putAgreementState(ctx, agreement); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "AcceptDataSharingAgreement", agreementGraph(agreement, userID, userMSPID), userID, userMSPID)
+}
+
+// RevokeDataSharingAgreement ends a proposed or active agreement on behalf of either
+// party. Bindings made under the agreement are kept; new ones are refused.
+func (s *SmartContract) RevokeDataSharingAgreement(ctx contractapi.TransactionContextInterface, agreementID string) error {
+	agreement, err := getAgreementState(ctx, agreementID)
+	if err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if !agreement.isParty(userMSPID) {
+		return forbidden(NODE_AGREEMENT, agreementID, "organization "+userMSPID+" is not a party to agreement "+agreementID)
+	}
+	if agreement.Status == STATUS_REVOKED {
+		return invalidState(NODE_AGREEMENT, agreementID, "agreement "+agreementID+" is already revoked")
+	}
+	agreement.Status = STATUS_REVOKED
+	agreement.RevokedBy = userID
+	if err := putAgreementState(ctx, agreement); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "RevokeDataSharingAgreement", agreementGraph(agreement, userID, userMSPID), userID, userMSPID)
+}
+
+func (s *SmartContract) GetDataSharingAgreement(ctx contractapi.TransactionContextInterface, agreementID string) (*DataSharingAgreement, error) {
+	agreement, err := getAgreementState(ctx, agreementID)
+	if err != nil {
+		return nil, err
+	}
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if !agreement.isParty(userMSPID) {
+		return nil, forbidden(NODE_AGREEMENT, agreementID, "organization "+userMSPID+" is not a party to agreement "+agreementID)
+	}
+	return agreement, nil
+}
+
+// GetDataSharingAgreements returns the agreements the organization of the caller is a party to
+func (s *SmartContract) GetDataSharingAgreements(ctx contractapi.TransactionContextInterface) ([]DataSharingAgreement, error) {
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionAgreements, "", "")
+	if err != nil {
+		return nil, internalError(NODE_AGREEMENT, "", err)
+	}
+	results := []DataSharingAgreement{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_AGREEMENT, "", err)
+		}
+		var agreement DataSharingAgreement
+		if err := json.Unmarshal(queryResponse.Value, &agreement); err != nil {
+			return nil, internalError(NODE_AGREEMENT, queryResponse.Key, err)
+		}
+		if agreement.isParty(userMSPID) {
+			results = append(results, agreement)
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_AGREEMENT, "", err)
+	}
+	return results, nil
+}
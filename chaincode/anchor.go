@@ -0,0 +1,129 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const anchorObject string = "anchor"
+
+// anchoredCollections lists the shared private collections whose writes are anchored
+// in public state. VirtualSilos and bindings are anchored in the implicit collection
+// of their organization.
+var anchoredCollections = []string{CollectionThingVisors, CollectionvThingTVs, CollectionFlavours, CollectionAgreements, CollectionUsage, CollectionQuotas, CollectionCatalog}
+
+// AssetAnchor is written to public state with every write of an asset, so that
+// organizations outside the collections can find the transactions that wrote it.
+// It holds no hash of the document: an unsalted hash of a guessable document would
+// disclose it, and the peers already keep the hash of the private data.
+type AssetAnchor struct {
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+}
+
+// AssetVerification tells whether a document hash is the hash of the current state
+// of an asset, and which transaction wrote that state
+type AssetVerification struct {
+	Collection   string `json:"collection"`
+	Key          string `json:"key"`
+	DocumentHash string `json:"documentHash"`
+	Verified     bool   `json:"verified"`
+	TxID         string `json:"txID"`
+	Time         string `json:"time"`
+}
+
+// anchorKey builds the public key of an asset; composite keys of the collections
+// cannot be nested in another composite key, so the key is encoded
+func anchorKey(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(anchorObject, []string{collection, base64.RawURLEncoding.EncodeToString([]byte(key))})
+}
+
+// putPrivateAsset writes an asset to its private collection and anchors the write
+// in public state
+func putPrivateAsset(ctx contractapi.TransactionContextInterface, collection string, key string, data []byte) error {
+	if err := ctx.GetStub().PutPrivateData(collection, key, data); err != nil {
+		return err
+	}
+	publicKey, err := anchorKey(ctx, collection, key)
+	if err != nil {
+		return err
+	}
+	anchor, err := json.Marshal(AssetAnchor{Collection: collection, Key: key})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(publicKey, anchor)
+}
+
+// deletePrivateAsset removes an asset from its private collection together with its anchor
+func deletePrivateAsset(ctx contractapi.TransactionContextInterface, collection string, key string) error {
+	if err := ctx.GetStub().DelPrivateData(collection, key); err != nil {
+		return err
+	}
+	publicKey, err := anchorKey(ctx, collection, key)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(publicKey)
+}
+
+// VerifyAsset checks the SHA-256 documentHash, hex encoded, of an asset document
+// against the hash of the private data the peers keep for the key of the asset in
+// one of the private collections, and returns the transaction that wrote it from
+// the history of the anchor. It does not need access to the collection, so auditors
+// of other organizations can use it. Earlier states of the asset are checked against
+// the private write hashes in the blocks of the transactions of the anchor history.
+func (s *SmartContract) VerifyAsset(ctx contractapi.TransactionContextInterface, collection string, key string, documentHash string) (*AssetVerification, error) {
+	if !containsString(anchoredCollections, collection) && !strings.HasPrefix(collection, implicitCollectionPrefix) {
+		return nil, invalidArgument("", key, "collection", "collection must be an implicit organization collection or one of "+strings.Join(anchoredCollections, ", "))
+	}
+	documentHash = strings.ToLower(documentHash)
+	if _, err := hex.DecodeString(documentHash); err != nil || len(documentHash) != 2*sha256.Size {
+		return nil, invalidArgument("", key, "documentHash", "documentHash must be a hex encoded SHA-256 hash")
+	}
+	verification := AssetVerification{Collection: collection, Key: key, DocumentHash: documentHash}
+	currentHash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+	if err != nil {
+		return nil, internalError("", key, err)
+	}
+	if len(currentHash) == 0 || hex.EncodeToString(currentHash) != documentHash {
+		return &verification, nil
+	}
+	verification.Verified = true
+	publicKey, err := anchorKey(ctx, collection, key)
+	if err != nil {
+		return nil, internalError("", key, err)
+	}
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(publicKey)
+	if err != nil {
+		return nil, internalError("", key, err)
+	}
+	// the history starts with the latest write, the one of the current state
+	if resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError("", key, err)
+		}
+		if !modification.IsDelete {
+			verification.TxID = modification.TxId
+			if modification.Timestamp != nil {
+				verification.Time = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError("", key, err)
+	}
+	return &verification, nil
+}
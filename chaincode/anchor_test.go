@@ -0,0 +1,63 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAsset(t *testing.T) {
+	stub := newTestStub()
+	provider := newTestContext(stub, "provider3", "Org1MSP", ROLE_PROVIDER)
+	flavour := Flavour{FlavourID: "kafka-f", Status: STATUS_READY}
+	if err := putFlavourState(provider, &flavour); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+	key := "kafka-f"
+	stored := stub.PvtState[CollectionFlavours][key]
+	sum := sha256.Sum256(stored)
+	currentHash := hex.EncodeToString(sum[:])
+	for publicKey, anchor := range stub.State {
+		if strings.Contains(string(anchor), currentHash) {
+			t.Errorf("anchor %q discloses the hash of the document", publicKey)
+		}
+	}
+	older := sha256.Sum256([]byte(`{"flavourID":"kafka-f"}`))
+	auditor := newTestContext(stub, "auditor1", "Org3MSP", "")
+	tests := []struct {
+		name         string
+		collection   string
+		documentHash string
+		verified     bool
+		code         string
+	}{
+		{name: "current document", collection: CollectionFlavours, documentHash: strings.ToUpper(currentHash), verified: true},
+		{name: "other document", collection: CollectionFlavours, documentHash: hex.EncodeToString(older[:])},
+		{name: "other collection", collection: CollectionThingVisors, documentHash: currentHash},
+		{name: "unknown collection", collection: "collectionSecrets", documentHash: currentHash, code: CODE_INVALID_ARGUMENT},
+		{name: "malformed hash", collection: CollectionFlavours, documentHash: currentHash[:10], code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verification, err := (&SmartContract{}).VerifyAsset(auditor, tt.collection, key, tt.documentHash)
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				return
+			}
+			if verification.Verified != tt.verified {
+				t.Errorf("verified = %v, want %v", verification.Verified, tt.verified)
+			}
+			if tt.verified && (verification.TxID != "tx0" || verification.Time != rfc3339(testTime)) {
+				t.Errorf("written by %q at %q, want tx0 at %s", verification.TxID, verification.Time, rfc3339(testTime))
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"io/ioutil"
@@ -33,6 +32,9 @@ const (
 	STATUS_PENDING  string = "pending"
 	STATUS_RUNNING  string = "running"
 	STATUS_STOPPING string = "stopping"
+	STATUS_STOPPED  string = "stopped"
+	STATUS_FAILED   string = "failed"
+	STATUS_READY    string = "ready"
 
 	NODE_ORG_PROVIDER string = "org-provider"
 	NODE_USER         string = "user"
@@ -67,41 +69,107 @@ type LogGraph struct {
 }
 
 type History struct {
-	EventName string     `json:"event_name"`
-	Time      string     `json:"time"`
-	TxID      string     `json:"tx_id"`
-	UserID    string     `json:"user_id"`
-	UserMSPID string     `json:"user_mspid"`
-	LogGraphs []LogGraph `json:"graph_data"`
+	EventName     string     `json:"event_name"`
+	Time          string     `json:"time"`
+	TxID          string     `json:"tx_id"`
+	UserID        string     `json:"user_id"`
+	UserMSPID     string     `json:"user_mspid"`
+	LogGraphs     []LogGraph `json:"graph_data"`
+	ChangedFields []string   `json:"changed_fields,omitempty"`
 }
 
 func SetHistory(ctx contractapi.TransactionContextInterface, EventName string, nodes []LogGraph, userID string, userMSPID string) error {
-	time, _ := ctx.GetStub().GetTxTimestamp()
-	history := History{
+	return emitHistory(ctx, &History{
 		EventName: EventName,
-		Time:      time.String(),
-		TxID:      ctx.GetStub().GetTxID(),
 		UserID:    userID,
 		UserMSPID: userMSPID,
 		LogGraphs: nodes,
+	})
+}
+
+// emitHistory stamps a History record with the transaction, sends it as the event
+// of the transaction and persists it
+func emitHistory(ctx contractapi.TransactionContextInterface, history *History) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
 	}
+	history.Time = now
+	history.TxID = ctx.GetStub().GetTxID()
 	byte, err := json.Marshal(history)
 	if err != nil {
+		return internalError("", "", err)
+	}
+	if err := ctx.GetStub().SetEvent(history.EventName, byte); err != nil {
+		return internalError("", "", err)
+	}
+	if err := recordHistory(ctx, history); err != nil {
+		return err
+	}
+	return recordGraph(ctx, history)
+}
+
+func getThingVisorState(ctx contractapi.TransactionContextInterface, id string) (*ThingVisor, error) {
+	byteData, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, id)
+	if err != nil {
+		return nil, internalError(NODE_THINGVISOR, id, err)
+	}
+	if byteData == nil {
+		return nil, notFound(NODE_THINGVISOR, id)
+	}
+	var thingVisor ThingVisor
+	if err := json.Unmarshal(byteData, &thingVisor); err != nil {
+		return nil, internalError(NODE_THINGVISOR, id, err)
+	}
+	return &thingVisor, nil
+}
+
+func putThingVisorState(ctx contractapi.TransactionContextInterface, thingVisor *ThingVisor) error {
+	if err := setLastModified(ctx, &thingVisor.LastModified, &thingVisor.LastModifiedBy); err != nil {
 		return err
 	}
-	return ctx.GetStub().SetEvent(EventName, byte)
+	thingVisor.Version++
+	assetJSON, err := json.Marshal(thingVisor)
+	if err != nil {
+		return internalError(NODE_THINGVISOR, thingVisor.ThingVisorID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionThingVisors, thingVisor.ThingVisorID, assetJSON); err != nil {
+		return internalError(NODE_THINGVISOR, thingVisor.ThingVisorID, err)
+	}
+	return nil
 }
 
 func (s *SmartContract) CreateThingVisor(ctx contractapi.TransactionContextInterface, id string, JSONstr string) error {
 	log.Println("Creating Vthing")
 	exists, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, id)
 	if err != nil {
-		return err
+		return internalError(NODE_THINGVISOR, id, err)
 	}
 	if exists != nil {
-		return errors.New("Add fails - thingVisor " + id + " already exists")
+		return alreadyExists(NODE_THINGVISOR, id)
+	}
+	var thingVisor ThingVisor
+	if err := decodeStrict(NODE_THINGVISOR, id, JSONstr, &thingVisor); err != nil {
+		return err
+	}
+	if err := validateThingVisor(id, &thingVisor); err != nil {
+		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionThingVisors, id, json.RawMessage(JSONstr)); err != nil {
+	if err := checkStatusUnchanged(NODE_THINGVISOR, id, STATUS_PENDING, thingVisor.Status); err != nil {
+		return err
+	}
+	if thingVisor.CreationTime, err = txTime(ctx); err != nil {
+		return err
+	}
+	thingVisor.Version = 0
+	thingVisor.OwnerMSPID, _ = ctx.GetClientIdentity().GetMSPID()
+	if err := takeSecret(ctx, NODE_THINGVISOR, id, &thingVisor.Params, &thingVisor.ParamsSalt, "", ""); err != nil {
+		return err
+	}
+	if err := initStatus(ctx, &thingVisor.Status, &thingVisor.StatusHistory); err != nil {
+		return err
+	}
+	if err := putThingVisorState(ctx, &thingVisor); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -113,8 +181,39 @@ func (s *SmartContract) CreateThingVisor(ctx contractapi.TransactionContextInter
 	}, userID, userMSPID)
 }
 
-func (s *SmartContract) UpdateThingVisor(ctx contractapi.TransactionContextInterface, id string, JSONstr string) error {
-	if err := ctx.GetStub().PutPrivateData(CollectionThingVisors, id, json.RawMessage(JSONstr)); err != nil {
+// UpdateThingVisor replaces the ThingVisor with JSONstr. The update is rejected with
+// CONFLICT unless expectedVersion is the current version of the ThingVisor; an
+// expectedVersion of 0 skips the check.
+func (s *SmartContract) UpdateThingVisor(ctx contractapi.TransactionContextInterface, id string, JSONstr string, expectedVersion int) error {
+	current, err := getThingVisorState(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_THINGVISOR, id, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	var thingVisor ThingVisor
+	if err := decodeStrict(NODE_THINGVISOR, id, JSONstr, &thingVisor); err != nil {
+		return err
+	}
+	if err := validateThingVisor(id, &thingVisor); err != nil {
+		return err
+	}
+	if err := checkStatusUnchanged(NODE_THINGVISOR, id, current.Status, thingVisor.Status); err != nil {
+		return err
+	}
+	if thingVisor.OwnerMSPID != "" && thingVisor.OwnerMSPID != current.OwnerMSPID {
+		return invalidArgument(NODE_THINGVISOR, id, "ownerMSPID", "ownerMSPID of a ThingVisor cannot be changed")
+	}
+	thingVisor.OwnerMSPID = current.OwnerMSPID
+	thingVisor.CreationTime = current.CreationTime
+	thingVisor.Version = current.Version
+	if err := takeSecret(ctx, NODE_THINGVISOR, id, &thingVisor.Params, &thingVisor.ParamsSalt, current.Params, current.ParamsSalt); err != nil {
+		return err
+	}
+	thingVisor.Status = current.Status
+	thingVisor.StatusHistory = current.StatusHistory
+	if err := putThingVisorState(ctx, &thingVisor); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -126,27 +225,24 @@ func (s *SmartContract) UpdateThingVisor(ctx contractapi.TransactionContextInter
 	}, userID, userMSPID)
 }
 
-func (s *SmartContract) UpdateThingVisorPartial(ctx contractapi.TransactionContextInterface, id string, tvDescription string, params string) error {
-	byteData, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, id)
-	var thingVisor ThingVisor
+// UpdateThingVisorPartial changes the description of a ThingVisor and, when they are
+// passed in the transient map, its params. The update is rejected with CONFLICT
+// unless expectedVersion is the current version; an expectedVersion of 0 skips the check.
+func (s *SmartContract) UpdateThingVisorPartial(ctx contractapi.TransactionContextInterface, id string, tvDescription string, expectedVersion int) error {
+	thingVisor, err := getThingVisorState(ctx, id)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(byteData, &thingVisor)
-	if err != nil {
+	if err := checkVersion(NODE_THINGVISOR, id, thingVisor.Version, expectedVersion); err != nil {
 		return err
 	}
 	if tvDescription != "" {
 		thingVisor.TvDescription = tvDescription
 	}
-	if params != "" {
-		thingVisor.Params = params
-	}
-	assetJSON, err := json.Marshal(thingVisor)
-	if err != nil {
+	if err := takeSecret(ctx, NODE_THINGVISOR, id, &thingVisor.Params, &thingVisor.ParamsSalt, thingVisor.Params, thingVisor.ParamsSalt); err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionThingVisors, id, assetJSON); err != nil {
+	if err := putThingVisorState(ctx, thingVisor); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -159,177 +255,244 @@ func (s *SmartContract) UpdateThingVisorPartial(ctx contractapi.TransactionConte
 }
 
 func (s *SmartContract) GetThingVisor(ctx contractapi.TransactionContextInterface, id string) (*ThingVisor, error) {
-	byteData, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, id)
-	var thingVisor ThingVisor
-	if byteData == nil {
-		return nil, errors.New("Operation fails - thingVisor " + id + " not exists")
-	}
-	if err != nil {
-		return nil, err
-	}
-	err = json.Unmarshal(byteData, &thingVisor)
+	thingVisor, err := getThingVisorState(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingTVs, vThingTVObject, []string{vThingTVPrefix, id})
 	if err != nil {
-		return nil, err
-	}
-	err = resultsIterator.Close()
-	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_THINGVISOR, id, err)
 	}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_THINGVISOR, id, err)
 		}
 		var vThingTV VThingTV
 		err = json.Unmarshal(queryResponse.Value, &vThingTV)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		thingVisor.VThings = append(thingVisor.VThings, vThingTV)
 	}
-	return &thingVisor, nil
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_THINGVISOR, id, err)
+	}
+	return thingVisor, nil
 }
 
 func (s *SmartContract) ThingVisorRunning(ctx contractapi.TransactionContextInterface, id string) error {
-	tv, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, id)
-	var thingVisor ThingVisor
+	thingVisor, err := getThingVisorState(ctx, id)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(tv, &thingVisor)
-	if err != nil || thingVisor.Status != STATUS_RUNNING {
-		return errors.New("ThingVisor " + id + "is not running!")
+	if thingVisor.Status != STATUS_RUNNING {
+		return invalidState(NODE_THINGVISOR, id, "ThingVisor "+id+" is not running")
 	}
 	return nil
 }
 
-func (s *SmartContract) DeleteThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) error {
-	userID, _ := ctx.GetClientIdentity().GetID()
-	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
-	graph := []LogGraph{
-		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
-		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
-		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_DELETED},
+// getVThingVSilosOfThingVisor returns the keys and the records of the bindings
+// whose vThing belongs to the given ThingVisor, grouped by vThing ID
+func getVThingVSilosOfThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) (map[string][]string, map[string][]VThingVSilo, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingVSilos, vThingVSiloObject, []string{vThingVSiloPrefix})
+	if err != nil {
+		return nil, nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 	}
-	args := ctx.GetStub().GetStringArgs()
-	for i := 2; i < len(args); i++ {
-		vThingID := args[i]
-		keyArr := strings.Split(vThingID, "/")
-		if keyArr[0] != ThingVisorID {
-			return errors.New("WARNING Delete fails - vThingID '" + vThingID + "' not valid")
-		}
-
-		key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
+	keys := map[string][]string{}
+	bindings := map[string][]VThingVSilo{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return errors.New("Failed to create composite key of '" + key + "'")
+			return nil, nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 		}
-		/*
-			if err := ctx.GetStub().DelPrivateData(CollectionvThingTVs, key); err != nil {
-				return errors.New("Failed to delete VTHING '" + vThingID + "'")
-			}*/
-		graph = append(graph, LogGraph{Source: "thingvisor-" + ThingVisorID, Target: "vthing-" + vThingID, SourceType: NODE_DELETED, TargetType: NODE_DELETED})
+		var vThingVSilo VThingVSilo
+		if err := json.Unmarshal(queryResponse.Value, &vThingVSilo); err != nil {
+			return nil, nil, internalError(vThingVSiloObject, "", err)
+		}
+		if !strings.HasPrefix(vThingVSilo.VThingID, ThingVisorID+"/") {
+			continue
+		}
+		keys[vThingVSilo.VThingID] = append(keys[vThingVSilo.VThingID], queryResponse.Key)
+		bindings[vThingVSilo.VThingID] = append(bindings[vThingVSilo.VThingID], vThingVSilo)
 	}
-	if err := ctx.GetStub().DelPrivateData(CollectionThingVisors, ThingVisorID); err != nil {
-		return err
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 	}
-	return SetHistory(ctx, "DeleteThingVisor", graph, userID, userMSPID)
+	return keys, bindings, nil
 }
 
-func (s *SmartContract) StopThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) error {
-	thingVisorByte, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, ThingVisorID)
+// checkForcedDetach refuses to detach bindings kept by another organization, since
+// only that organization can remove their records
+func checkForcedDetach(ctx contractapi.TransactionContextInterface, kind string, id string, bindings []VThingVSilo) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return err
+		return internalError(kind, id, err)
 	}
-	if thingVisorByte == nil {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " not exist")
+	for _, binding := range bindings {
+		if binding.OwnerMSPID != mspID {
+			return invalidState(kind, id, "vThing "+binding.VThingID+" is bound to VirtualSilo "+binding.VSiloID+" of organization "+binding.OwnerMSPID+", which has to detach it first")
+		}
 	}
-	var thingVisor ThingVisor
-	if err := json.Unmarshal(thingVisorByte, &thingVisor); err != nil {
-		return err
+	return nil
+}
+
+// detachVThings deletes the bindings of vThings that their ThingVisor is removing and
+// returns the deletion edges for the history graph. While silos are still bound to one
+// of the vThings the removal is refused, unless force is set; kind and id name the
+// asset being removed in the error.
+func detachVThings(ctx contractapi.TransactionContextInterface, kind string, id string, ThingVisorID string, VThingIDs []string, force bool) ([]LogGraph, error) {
+	bindingKeys, bindings, err := getVThingVSilosOfThingVisor(ctx, ThingVisorID)
+	if err != nil {
+		return nil, err
 	}
-	if thingVisor.Status != STATUS_RUNNING {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " is not ready")
+	for _, VThingID := range VThingIDs {
+		if !force && len(bindings[VThingID]) > 0 {
+			return nil, invalidState(kind, id, "vThing "+VThingID+" is still bound to VirtualSilo "+bindings[VThingID][0].VSiloID+", use force to detach it")
+		}
+		if err := checkForcedDetach(ctx, kind, id, bindings[VThingID]); err != nil {
+			return nil, err
+		}
 	}
-	thingVisor.Status = STATUS_STOPPING
-	assetJSON, err := json.Marshal(thingVisor)
+	var graph []LogGraph
+	var detached []string
+	for _, VThingID := range VThingIDs {
+		for i, key := range bindingKeys[VThingID] {
+			binding := bindings[VThingID][i]
+			if err := deleteBinding(ctx, key, binding.VSiloID, VThingID, binding.OwnerMSPID); err != nil {
+				return nil, err
+			}
+			detached = append(detached, binding.VSiloID)
+			graph = append(graph, LogGraph{Source: "silo-" + binding.VSiloID, Target: "vthing-" + VThingID, SourceType: NODE_VSILO, TargetType: NODE_DELETED})
+		}
+	}
+	if err := releaseBindings(ctx, detached); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// DeleteThingVisor removes a ThingVisor together with its vThings. While silos are
+// still bound to one of the vThings the deletion is refused, unless force is set,
+// in which case the bindings are detached as well. Bindings of silos of other
+// organizations are never detached; they have to be deleted by their owners first.
+func (s *SmartContract) DeleteThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, force bool) error {
+	if _, err := getThingVisorState(ctx, ThingVisorID); err != nil {
+		return err
+	}
+	vThings, err := s.GetAllVThingOfThingVisor(ctx, ThingVisorID)
 	if err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionThingVisors, ThingVisorID, assetJSON); err != nil {
+	var VThingIDs []string
+	for _, vThing := range vThings {
+		VThingIDs = append(VThingIDs, vThing.ID)
+	}
+	detachGraph, err := detachVThings(ctx, NODE_THINGVISOR, ThingVisorID, ThingVisorID, VThingIDs, force)
+	if err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
 	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
-	return SetHistory(ctx, "StopThingVisor", []LogGraph{
+	graph := []LogGraph{
 		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
 		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
-		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
-	}, userID, userMSPID)
+		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_DELETED},
+	}
+	graph = append(graph, detachGraph...)
+	for _, vThing := range vThings {
+		key, err := vThingTVKey(ctx, vThing.ID)
+		if err != nil {
+			return err
+		}
+		if err := deletePrivateAsset(ctx, CollectionvThingTVs, key); err != nil {
+			return internalError(NODE_VTHING, vThing.ID, err)
+		}
+		graph = append(graph, LogGraph{Source: "thingvisor-" + ThingVisorID, Target: "vthing-" + vThing.ID, SourceType: NODE_DELETED, TargetType: NODE_DELETED})
+	}
+	if err := deletePrivateAsset(ctx, CollectionThingVisors, ThingVisorID); err != nil {
+		return internalError(NODE_THINGVISOR, ThingVisorID, err)
+	}
+	if err := deleteSecretHash(ctx, NODE_THINGVISOR, ThingVisorID); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "DeleteThingVisor", graph, userID, userMSPID)
 }
 
 type VThingTV struct {
-	Label       string `json:"label"`
-	ID          string `json:"id"`
-	Description string `json:"description"`
-	Type        string `json:"type"`
-	Endpoint    string `json:"endpoint"`
+	Label          string       `json:"label"`
+	ID             string       `json:"id"`
+	Description    string       `json:"description"`
+	Type           string       `json:"type"`
+	Endpoint       string       `json:"endpoint"`
+	Policy         *UsagePolicy `json:"policy,omitempty"`
+	Version        int          `json:"version"`
+	LastModified   string       `json:"lastModified"`
+	LastModifiedBy string       `json:"lastModifiedBy"`
 }
 
 type ThingVisor struct {
-	ThingVisorID               string       `json:"thingVisorID"`
-	CreationTime               string       `json:"creationTime"`
-	TvDescription              string       `json:"tvDescription"`
-	Status                     string       `json:"status"`
-	DebugMode                  bool         `json:"debug_mode"`
-	IpAddress                  string       `json:"ipAddress"`
-	DeploymentName             string       `json:"deploymentName"`
-	ServiceName                string       `json:"serviceName"`
-	ContainerID                string       `json:"containerID"`
-	VThings                    []VThingTV   `json:"vThings"` // 型は一定? (label id description)
-	Params                     string       `json:"params"`
-	MQTTDataBroker             *MQTTProfile `json:"MQTTDataBroker"`
-	MQTTControlBroker          *MQTTProfile `json:"MQTTControlBroker"`
-	AdditionalServicesNames    []string     `json:"additionalServicesNames"`
-	AdditionalDeploymentsNames []string     `json:"additionalDeploymentsNames"`
+	ThingVisorID               string             `json:"thingVisorID"`
+	CreationTime               string             `json:"creationTime"`
+	TvDescription              string             `json:"tvDescription"`
+	Status                     string             `json:"status"`
+	StatusHistory              []StatusTransition `json:"statusHistory"`
+	DebugMode                  bool               `json:"debug_mode"`
+	IpAddress                  string             `json:"ipAddress"`
+	DeploymentName             string             `json:"deploymentName"`
+	ServiceName                string             `json:"serviceName"`
+	ContainerID                string             `json:"containerID"`
+	VThings                    []VThingTV         `json:"vThings"` // 型は一定? (label id description)
+	Params                     string             `json:"params"`
+	ParamsSalt                 string             `json:"paramsSalt"`
+	OwnerMSPID                 string             `json:"ownerMSPID"`
+	MQTTDataBroker             *MQTTProfile       `json:"MQTTDataBroker"`
+	MQTTControlBroker          *MQTTProfile       `json:"MQTTControlBroker"`
+	AdditionalServicesNames    []string           `json:"additionalServicesNames"`
+	AdditionalDeploymentsNames []string           `json:"additionalDeploymentsNames"`
+	Version                    int                `json:"version"`
+	LastModified               string             `json:"lastModified"`
+	LastModifiedBy             string             `json:"lastModifiedBy"`
 }
 
 func (s *SmartContract) GetAllThingVisors(ctx contractapi.TransactionContextInterface) ([]ThingVisor, error) {
 	vThingIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingTVs, vThingTVObject, []string{vThingTVPrefix})
+	if err != nil {
+		return nil, internalError(NODE_VTHING, "", err)
+	}
 	tvIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionThingVisors, "", "")
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_THINGVISOR, "", err)
 	}
 	var vThings []VThingTV
 	for vThingIterator.HasNext() {
 		queryResponse, err := vThingIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		var vThingTV VThingTV
 		err = json.Unmarshal(queryResponse.Value, &vThingTV)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		vThings = append(vThings, vThingTV)
 	}
 	err = vThingIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VTHING, "", err)
 	}
 	var results []ThingVisor
 	for tvIterator.HasNext() {
 		queryResponse, err := tvIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_THINGVISOR, "", err)
 		}
 		var thingVisor ThingVisor
 		err = json.Unmarshal(queryResponse.Value, &thingVisor)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_THINGVISOR, queryResponse.Key, err)
 		}
 		for _, v := range vThings {
 			keyArr := strings.Split(v.ID, "/")
@@ -341,7 +504,7 @@ func (s *SmartContract) GetAllThingVisors(ctx contractapi.TransactionContextInte
 	}
 	err = tvIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_THINGVISOR, "", err)
 	}
 	return results, nil
 }
@@ -349,104 +512,130 @@ func (s *SmartContract) GetAllThingVisors(ctx contractapi.TransactionContextInte
 func (s *SmartContract) GetAllVThings(ctx contractapi.TransactionContextInterface) ([]VThingTV, error) {
 	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingTVs, vThingTVObject, []string{vThingTVPrefix})
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VTHING, "", err)
 	}
 	var results []VThingTV
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		var vThingTV VThingTV
 		err = json.Unmarshal(queryResponse.Value, &vThingTV)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		results = append(results, vThingTV)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VTHING, "", err)
 	}
 	return results, nil
 }
 
-func (s *SmartContract) GetVThingByID(ctx contractapi.TransactionContextInterface, VThingID string) (*VThingTV, error) {
-	var vThing VThingTV
-	keyArr := strings.Split(VThingID, "/")
-	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
+func getVThingState(ctx contractapi.TransactionContextInterface, VThingID string) (*VThingTV, error) {
+	key, err := vThingTVKey(ctx, VThingID)
 	if err != nil {
-		return nil, errors.New("Get VThing " + VThingID + "failed")
+		return nil, err
 	}
 	byteData, err := ctx.GetStub().GetPrivateData(CollectionvThingTVs, key)
-	if byteData == nil {
-		return nil, errors.New("Get VThing Failed - VThing " + VThingID + " not exists")
-	}
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VTHING, VThingID, err)
 	}
-	err = json.Unmarshal(byteData, &vThing)
-	if err != nil {
-		return nil, err
+	if byteData == nil {
+		return nil, notFound(NODE_VTHING, VThingID)
+	}
+	var vThing VThingTV
+	if err := json.Unmarshal(byteData, &vThing); err != nil {
+		return nil, internalError(NODE_VTHING, VThingID, err)
 	}
-	return &vThing, err
+	return &vThing, nil
+}
+
+func (s *SmartContract) GetVThingByID(ctx contractapi.TransactionContextInterface, VThingID string) (*VThingTV, error) {
+	return getVThingState(ctx, VThingID)
 }
 
 func (s *SmartContract) GetAllVThingOfThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) ([]VThingTV, error) {
 	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingTVs, vThingTVObject, []string{vThingTVPrefix, ThingVisorID})
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 	}
 	var results []VThingTV
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 		}
 		var vThingTV VThingTV
 		err = json.Unmarshal(queryResponse.Value, &vThingTV)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VTHING, "", err)
 		}
 		results = append(results, vThingTV)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_THINGVISOR, ThingVisorID, err)
 	}
 	return results, nil
 }
 
-func (s *SmartContract) AddVThingToThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingData string) error {
-	thingVisorByte, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, ThingVisorID)
+func vThingTVKey(ctx contractapi.TransactionContextInterface, VThingID string) (string, error) {
+	thingVisorID, name, err := parseVThingID(VThingID)
+	if err != nil {
+		return "", err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, thingVisorID, name})
+	if err != nil {
+		return "", internalError(NODE_VTHING, VThingID, err)
+	}
+	return key, nil
+}
+
+func putVThingState(ctx contractapi.TransactionContextInterface, vThing *VThingTV) error {
+	if err := setLastModified(ctx, &vThing.LastModified, &vThing.LastModifiedBy); err != nil {
+		return err
+	}
+	vThing.Version++
+	key, err := vThingTVKey(ctx, vThing.ID)
 	if err != nil {
 		return err
 	}
-	if thingVisorByte == nil {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " not exist")
+	vThingByte, err := json.Marshal(vThing)
+	if err != nil {
+		return internalError(NODE_VTHING, vThing.ID, err)
 	}
-	var thingVisor ThingVisor
-	if err := json.Unmarshal(thingVisorByte, &thingVisor); err != nil {
+	if err := putPrivateAsset(ctx, CollectionvThingTVs, key, vThingByte); err != nil {
+		return internalError(NODE_VTHING, vThing.ID, err)
+	}
+	return nil
+}
+
+func (s *SmartContract) AddVThingToThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingData string) error {
+	thingVisor, err := getThingVisorState(ctx, ThingVisorID)
+	if err != nil {
 		return err
 	}
 	if thingVisor.Status != STATUS_RUNNING {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " is not ready")
+		return invalidState(NODE_THINGVISOR, ThingVisorID, "Add fails - ThingVisor "+ThingVisorID+" is not running")
 	}
 	var newVThing VThingTV
-	newVThingByte := json.RawMessage(vThingData)
-	if err := json.Unmarshal(newVThingByte, &newVThing); err != nil {
+	if err := decodeStrict(NODE_VTHING, "", vThingData, &newVThing); err != nil {
 		return err
 	}
-	newVThingID := newVThing.ID
-	keyArr := strings.Split(newVThingID, "/")
-	if keyArr[0] != ThingVisorID {
-		return errors.New("WARNING Add fails - vThingID '" + newVThingID + "' not valid")
+	if err := validateVThing(ThingVisorID, &newVThing); err != nil {
+		return err
 	}
-	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
-	if err != nil {
+	newVThingID := newVThing.ID
+	newVThing.Version = 0
+	if current, err := getVThingState(ctx, newVThingID); err == nil {
+		newVThing.Version = current.Version
+	} else if e, ok := err.(*ChaincodeError); !ok || e.Code != CODE_NOT_FOUND {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionvThingTVs, key, newVThingByte); err != nil {
+	if err := putVThingState(ctx, &newVThing); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -459,18 +648,33 @@ func (s *SmartContract) AddVThingToThingVisor(ctx contractapi.TransactionContext
 	}, userID, userMSPID)
 }
 
-func (s *SmartContract) UpdateVThingOfThingVisor(ctx contractapi.TransactionContextInterface, VThingID string, vThingData string) error {
+// UpdateVThingOfThingVisor replaces a vThing with vThingData. The update is rejected
+// with CONFLICT unless expectedVersion is the current version of the vThing; an
+// expectedVersion of 0 skips the check.
+func (s *SmartContract) UpdateVThingOfThingVisor(ctx contractapi.TransactionContextInterface, VThingID string, vThingData string, expectedVersion int) error {
 	var VThing VThingTV
-	VThingByte := json.RawMessage(vThingData)
-	if err := json.Unmarshal(VThingByte, &VThing); err != nil {
+	if err := decodeStrict(NODE_VTHING, VThingID, vThingData, &VThing); err != nil {
+		return err
+	}
+	thingVisorID, _, err := parseVThingID(VThingID)
+	if err != nil {
+		return err
+	}
+	if VThing.ID != VThingID {
+		return invalidArgument(NODE_VTHING, VThingID, "id", "id '"+VThing.ID+"' does not match '"+VThingID+"'")
+	}
+	if err := validateVThing(thingVisorID, &VThing); err != nil {
 		return err
 	}
-	keyArr := strings.Split(VThingID, "/")
-	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
+	current, err := getVThingState(ctx, VThingID)
 	if err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionvThingTVs, key, VThingByte); err != nil {
+	if err := checkVersion(NODE_VTHING, VThingID, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	VThing.Version = current.Version
+	if err := putVThingState(ctx, &VThing); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -478,105 +682,123 @@ func (s *SmartContract) UpdateVThingOfThingVisor(ctx contractapi.TransactionCont
 	return SetHistory(ctx, "UpdateVThingOfThingVisor", []LogGraph{
 		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
 		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
-		{Source: "user-" + userID, Target: "thingvisor-" + keyArr[0], SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
-		{Source: "thingvisor-" + keyArr[0], Target: "vthing-" + VThingID, SourceType: NODE_USER, TargetType: NODE_VTHING},
+		{Source: "user-" + userID, Target: "thingvisor-" + thingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+		{Source: "thingvisor-" + thingVisorID, Target: "vthing-" + VThingID, SourceType: NODE_USER, TargetType: NODE_VTHING},
 	}, userID, userMSPID)
 }
 
 func (s *SmartContract) GetVThingOfThingVisor(ctx contractapi.TransactionContextInterface, VThingID string) (*VThingTV, error) {
-	keyArr := strings.Split(VThingID, "/")
-	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
-	if err != nil {
-		return nil, errors.New("Error to create composite key of" + VThingID)
-	}
-	byteData, err := ctx.GetStub().GetPrivateData(CollectionvThingTVs, key)
-	var vThing VThingTV
-	if byteData == nil {
-		return nil, errors.New("VThing " + VThingID + " not exists")
-	}
-	if err != nil {
-		return nil, err
-	}
-	err = json.Unmarshal(byteData, &vThing)
-	if err != nil {
-		return nil, err
-	}
-	return &vThing, nil
+	return getVThingState(ctx, VThingID)
 }
 
-func (s *SmartContract) DeleteVThingFromThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingData string) error {
-	thingVisorByte, err := ctx.GetStub().GetPrivateData(CollectionThingVisors, ThingVisorID)
+// DeleteVThingFromThingVisor removes a vThing. While silos are still bound to it the
+// removal is refused, unless force is set, in which case the bindings are detached.
+func (s *SmartContract) DeleteVThingFromThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingData string, force bool) error {
+	thingVisor, err := getThingVisorState(ctx, ThingVisorID)
 	if err != nil {
 		return err
 	}
-	if thingVisorByte == nil {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " not exist")
-	}
-	var thingVisor ThingVisor
-	if err := json.Unmarshal(thingVisorByte, &thingVisor); err != nil {
-		return err
-	}
 	if thingVisor.Status != STATUS_RUNNING {
-		return errors.New("WARNING Add fails - ThingVisor " + ThingVisorID + " is not ready")
+		return invalidState(NODE_THINGVISOR, ThingVisorID, "Delete fails - ThingVisor "+ThingVisorID+" is not running")
 	}
 	var VThing VThingTV
-	VThingByte := json.RawMessage(vThingData)
-	if err := json.Unmarshal(VThingByte, &VThing); err != nil {
+	if err := decodeStrict(NODE_VTHING, "", vThingData, &VThing); err != nil {
 		return err
 	}
-	VThingID := VThing.ID
-	keyArr := strings.Split(VThingID, "/")
-	if keyArr[0] != ThingVisorID {
-		return errors.New("WARNING Add fails - vThingID '" + VThingID + "' not valid")
+	if err := validateVThing(ThingVisorID, &VThing); err != nil {
+		return err
 	}
-	key, err := ctx.GetStub().CreateCompositeKey(vThingTVObject, []string{vThingTVPrefix, keyArr[0], keyArr[1]})
+	VThingID := VThing.ID
+	detachGraph, err := detachVThings(ctx, NODE_VTHING, VThingID, ThingVisorID, []string{VThingID}, force)
 	if err != nil {
 		return err
 	}
-	if err := ctx.GetStub().DelPrivateData(CollectionvThingTVs, key); err != nil {
+	key, err := vThingTVKey(ctx, VThingID)
+	if err != nil {
 		return err
 	}
+	if err := deletePrivateAsset(ctx, CollectionvThingTVs, key); err != nil {
+		return internalError(NODE_VTHING, VThingID, err)
+	}
 	userID, _ := ctx.GetClientIdentity().GetID()
 	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
-	return SetHistory(ctx, "DeleteVThingFromThingVisor", []LogGraph{
+	graph := []LogGraph{
 		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
 		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
 		{Source: "thingvisor-" + ThingVisorID, Target: "vthing-" + VThingID, SourceType: NODE_THINGVISOR, TargetType: NODE_DELETED},
-	}, userID, userMSPID)
+	}
+	return SetHistory(ctx, "DeleteVThingFromThingVisor", append(graph, detachGraph...), userID, userMSPID)
 
 }
 
 type Flavour struct {
-	FlavourID          string   `json:"flavourID"`
-	FlavourParams      string   `json:"flavourParams"`
-	ImageName          []string `json:"imageName"`
-	FlavourDescription string   `json:"flavourDescription"`
-	CreationTime       string   `json:"creationTime"`
-	Status             string   `json:"status"`
-	YamlFiles          []string `json:"yamlFiles"`
+	FlavourID          string             `json:"flavourID"`
+	FlavourParams      string             `json:"flavourParams"`
+	ImageName          []string           `json:"imageName"`
+	FlavourDescription string             `json:"flavourDescription"`
+	CreationTime       string             `json:"creationTime"`
+	Status             string             `json:"status"`
+	StatusHistory      []StatusTransition `json:"statusHistory"`
+	YamlFiles          []string           `json:"yamlFiles"`
+	Version            int                `json:"version"`
+	LastModified       string             `json:"lastModified"`
+	LastModifiedBy     string             `json:"lastModifiedBy"`
 }
 
-func (s *SmartContract) AddFlavour(ctx contractapi.TransactionContextInterface, flavourID string) error {
+func getFlavourState(ctx contractapi.TransactionContextInterface, flavourID string) (*Flavour, error) {
 	flavourByte, err := ctx.GetStub().GetPrivateData(CollectionFlavours, flavourID)
 	if err != nil {
+		return nil, internalError(NODE_FLAVOUR, flavourID, err)
+	}
+	if flavourByte == nil {
+		return nil, notFound(NODE_FLAVOUR, flavourID)
+	}
+	var flavour Flavour
+	if err := json.Unmarshal(flavourByte, &flavour); err != nil {
+		return nil, internalError(NODE_FLAVOUR, flavourID, err)
+	}
+	return &flavour, nil
+}
+
+func putFlavourState(ctx contractapi.TransactionContextInterface, flavour *Flavour) error {
+	if err := setLastModified(ctx, &flavour.LastModified, &flavour.LastModifiedBy); err != nil {
 		return err
 	}
+	flavour.Version++
+	data, err := json.Marshal(flavour)
+	if err != nil {
+		return internalError(NODE_FLAVOUR, flavour.FlavourID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionFlavours, flavour.FlavourID, data); err != nil {
+		return internalError(NODE_FLAVOUR, flavour.FlavourID, err)
+	}
+	return nil
+}
+
+func (s *SmartContract) AddFlavour(ctx contractapi.TransactionContextInterface, flavourID string) error {
+	flavourByte, err := ctx.GetStub().GetPrivateData(CollectionFlavours, flavourID)
+	if err != nil {
+		return internalError(NODE_FLAVOUR, flavourID, err)
+	}
 	if flavourByte != nil {
-		return errors.New("WARNING Add fails - Flavour " + flavourID + " already exists")
+		return alreadyExists(NODE_FLAVOUR, flavourID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
 	}
-	data, err := json.Marshal(Flavour{
+	flavour := Flavour{
 		FlavourID:          flavourID,
 		FlavourParams:      "",
 		ImageName:          []string{},
 		FlavourDescription: "",
-		CreationTime:       "",
-		Status:             STATUS_PENDING,
+		CreationTime:       now,
 		YamlFiles:          []string{},
-	})
-	if err != nil {
+	}
+	if err := initStatus(ctx, &flavour.Status, &flavour.StatusHistory); err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionFlavours, flavourID, data); err != nil {
+	if err := putFlavourState(ctx, &flavour); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -588,36 +810,49 @@ func (s *SmartContract) AddFlavour(ctx contractapi.TransactionContextInterface,
 	}, userID, userMSPID)
 }
 
-func (s *SmartContract) UpdateFlavour(ctx contractapi.TransactionContextInterface, flavourID string, flavourData string) error {
-	flavourByte, err := ctx.GetStub().GetPrivateData(CollectionFlavours, flavourID)
+// UpdateFlavour replaces a Flavour with flavourData. The update is rejected with
+// CONFLICT unless expectedVersion is the current version of the Flavour; an
+// expectedVersion of 0 skips the check.
+func (s *SmartContract) UpdateFlavour(ctx contractapi.TransactionContextInterface, flavourID string, flavourData string, expectedVersion int) error {
+	current, err := getFlavourState(ctx, flavourID)
 	if err != nil {
 		return err
 	}
-	if flavourByte == nil {
-		return errors.New("Update Flavour fails - Flavour " + flavourID + " not exist")
+	if err := checkVersion(NODE_FLAVOUR, flavourID, current.Version, expectedVersion); err != nil {
+		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionFlavours, flavourID, json.RawMessage(flavourData)); err != nil {
+	var flavour Flavour
+	if err := decodeStrict(NODE_FLAVOUR, flavourID, flavourData, &flavour); err != nil {
 		return err
 	}
-	userID, _ := ctx.GetClientIdentity().GetID()
-	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
-	return SetHistory(ctx, "UpdateFlavour", []LogGraph{
-		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+	if err := validateFlavour(flavourID, &flavour); err != nil {
+		return err
+	}
+	if err := checkStatusUnchanged(NODE_FLAVOUR, flavourID, current.Status, flavour.Status); err != nil {
+		return err
+	}
+	flavour.CreationTime = current.CreationTime
+	flavour.Version = current.Version
+	flavour.Status = current.Status
+	flavour.StatusHistory = current.StatusHistory
+	if err := putFlavourState(ctx, &flavour); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return SetHistory(ctx, "UpdateFlavour", []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
 		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
 		{Source: "user-" + userID, Target: "flavour-" + flavourID, SourceType: NODE_USER, TargetType: NODE_FLAVOUR},
 	}, userID, userMSPID)
 }
 
 func (s *SmartContract) DeleteFlavour(ctx contractapi.TransactionContextInterface, flavourID string) error {
-	flavourByte, err := ctx.GetStub().GetPrivateData(CollectionFlavours, flavourID)
-	if err != nil {
+	if _, err := getFlavourState(ctx, flavourID); err != nil {
 		return err
 	}
-	if flavourByte == nil {
-		return errors.New("Delete Flavour fails - Flavour " + flavourID + " not exist")
-	}
-	if err := ctx.GetStub().DelPrivateData(CollectionFlavours, flavourID); err != nil {
-		return err
+	if err := deletePrivateAsset(ctx, CollectionFlavours, flavourID); err != nil {
+		return internalError(NODE_FLAVOUR, flavourID, err)
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
 	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
@@ -631,89 +866,171 @@ func (s *SmartContract) DeleteFlavour(ctx contractapi.TransactionContextInterfac
 func (s *SmartContract) GetAllFlavours(ctx contractapi.TransactionContextInterface) ([]Flavour, error) {
 	flavourIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionFlavours, "", "")
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_FLAVOUR, "", err)
 	}
 	var results []Flavour
 	for flavourIterator.HasNext() {
 		queryResponse, err := flavourIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_FLAVOUR, "", err)
 		}
 		var flavour Flavour
 		err = json.Unmarshal(queryResponse.Value, &flavour)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_FLAVOUR, queryResponse.Key, err)
 		}
 		results = append(results, flavour)
 	}
 	err = flavourIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_FLAVOUR, "", err)
 	}
 	return results, nil
 }
 
 func (s *SmartContract) GetFlavour(ctx contractapi.TransactionContextInterface, flavourID string) (*Flavour, error) {
-	ctx.GetClientIdentity()
-	byteData, err := ctx.GetStub().GetPrivateData(CollectionFlavours, flavourID)
-	var flavour Flavour
+	return getFlavourState(ctx, flavourID)
+}
+
+// VirtualSilo and VThingVSilo records share the implicit collection of their
+// organization; DocType tells them apart in queries
+type VirtualSilo struct {
+	DocType                    string             `json:"docType"`
+	VSiloID                    string             `json:"vSiloID"`
+	VSiloName                  string             `json:"vSiloName"`
+	CreationTime               string             `json:"creationTime"`
+	ContainerName              string             `json:"containerName"`
+	ContainerID                string             `json:"containerID"`
+	DeploymentName             string             `json:"deploymentName"`
+	ServiceName                string             `json:"serviceName"`
+	IPAddress                  string             `json:"ipAddress"`
+	FlavourID                  string             `json:"flavourID"`
+	FlavourParams              string             `json:"flavourParams"`
+	FlavourParamsSalt          string             `json:"flavourParamsSalt"`
+	TenantID                   string             `json:"tenantID"`
+	Owner                      string             `json:"owner"`
+	OwnerMSPID                 string             `json:"ownerMSPID"`
+	Status                     string             `json:"status"`
+	StatusHistory              []StatusTransition `json:"statusHistory"`
+	Port                       string             `json:"port"`
+	MQTTDataBroker             *MQTTProfile       `json:"MQTTDataBroker"`
+	MQTTControlBroker          *MQTTProfile       `json:"MQTTControlBroker"`
+	AdditionalServicesNames    []string           `json:"additionalServicesNames"`
+	AdditionalDeploymentsNames []string           `json:"additionalDeploymentsNames"`
+	Version                    int                `json:"version"`
+	LastModified               string             `json:"lastModified"`
+	LastModifiedBy             string             `json:"lastModifiedBy"`
+}
+
+func vSiloKey(ctx contractapi.TransactionContextInterface, VSiloID string) (string, error) {
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return "", err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(vSiloObject, []string{vSiloPrefix, tenantID, vSiloName})
+	if err != nil {
+		return "", internalError(NODE_VSILO, VSiloID, err)
+	}
+	return key, nil
+}
+
+func getVirtualSiloState(ctx contractapi.TransactionContextInterface, VSiloID string) (*VirtualSilo, error) {
+	key, err := vSiloKey(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	byteData, err := getOrgRecord(ctx, CollectionvSilos, key, NODE_VSILO, VSiloID)
 	if err != nil {
 		return nil, err
 	}
 	if byteData == nil {
-		return nil, errors.New("Get Flavour fails - Flavour " + flavourID + " not exist")
+		return nil, notFound(NODE_VSILO, VSiloID)
 	}
-	err = json.Unmarshal(byteData, &flavour)
-	return &flavour, nil
+	var silo VirtualSilo
+	if err := json.Unmarshal(byteData, &silo); err != nil {
+		return nil, internalError(NODE_VSILO, VSiloID, err)
+	}
+	return &silo, nil
 }
 
-type VirtualSilo struct {
-	VSiloID                    string       `json:"vSiloID"`
-	VSiloName                  string       `json:"vSiloName"`
-	CreationTime               string       `json:"creationTime"`
-	ContainerName              string       `json:"containerName"`
-	ContainerID                string       `json:"containerID"`
-	DeploymentName             string       `json:"deploymentName"`
-	ServiceName                string       `json:"serviceName"`
-	IPAddress                  string       `json:"ipAddress"`
-	FlavourID                  string       `json:"flavourID"`
-	FlavourParams              string       `json:"flavourParams"`
-	TenantID                   string       `json:"tenantID"`
-	Status                     string       `json:"status"`
-	Port                       string       `json:"port"`
-	MQTTDataBroker             *MQTTProfile `json:"MQTTDataBroker"`
-	MQTTControlBroker          *MQTTProfile `json:"MQTTControlBroker"`
-	AdditionalServicesNames    []string     `json:"additionalServicesNames"`
-	AdditionalDeploymentsNames []string     `json:"additionalDeploymentsNames"`
+func putVirtualSiloState(ctx contractapi.TransactionContextInterface, silo *VirtualSilo) error {
+	if err := setLastModified(ctx, &silo.LastModified, &silo.LastModifiedBy); err != nil {
+		return err
+	}
+	silo.DocType = vSiloObject
+	silo.Version++
+	key, err := vSiloKey(ctx, silo.VSiloID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(silo)
+	if err != nil {
+		return internalError(NODE_VSILO, silo.VSiloID, err)
+	}
+	entry := OrgIndexEntry{VSiloID: silo.VSiloID, OwnerMSPID: silo.OwnerMSPID}
+	if err := putOrgRecord(ctx, CollectionvSilos, key, entry, data); err != nil {
+		return internalError(NODE_VSILO, silo.VSiloID, err)
+	}
+	return nil
 }
 
 func (s *SmartContract) AddVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string, flavourID string) error {
-	keyArr := strings.Split(VSiloID, "_")
-	key, err := ctx.GetStub().CreateCompositeKey(vSiloObject, []string{vSiloPrefix, keyArr[0], keyArr[1]}) //keyArr[1] is flavourID
+	tenantID, _, err := parseVSiloID(VSiloID)
 	if err != nil {
-		return errors.New("Generate key of " + VSiloID + " failed.")
+		return err
 	}
-	siloByte, err := ctx.GetStub().GetPrivateData(CollectionvSilos, key)
+	if flavourID == "" {
+		return invalidArgument(NODE_VSILO, VSiloID, "flavourID", "flavourID must not be empty")
+	}
+	flavour, err := getFlavourState(ctx, flavourID)
 	if err != nil {
 		return err
 	}
+	if flavour.Status != STATUS_READY {
+		return invalidState(NODE_FLAVOUR, flavourID, "Add fails - Flavour "+flavourID+" is not ready")
+	}
+	key, err := vSiloKey(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	siloByte, err := ctx.GetStub().GetPrivateData(CollectionvSilos, key)
+	if err != nil {
+		return internalError(NODE_VSILO, VSiloID, err)
+	}
 	if siloByte != nil {
-		return errors.New("WARNING Add fails - VirtualSilo " + VSiloID + " already exists")
+		return alreadyExists(NODE_VSILO, VSiloID)
+	}
+	if err := checkTenant(ctx, NODE_VSILO, VSiloID, tenantID); err != nil {
+		return err
+	}
+	if err := reserveSilo(ctx, tenantID, VSiloID, flavourID); err != nil {
+		return err
 	}
-	data, err := json.Marshal(VirtualSilo{
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	silo := VirtualSilo{
 		VSiloID:                    VSiloID,
+		TenantID:                   tenantID,
+		CreationTime:               now,
+		FlavourID:                  flavourID,
+		Owner:                      userID,
+		OwnerMSPID:                 userMSPID,
 		AdditionalServicesNames:    []string{},
 		AdditionalDeploymentsNames: []string{},
-		Status:                     STATUS_PENDING,
-	})
-	if err != nil {
+	}
+	if err := takeSecret(ctx, NODE_VSILO, VSiloID, &silo.FlavourParams, &silo.FlavourParamsSalt, "", ""); err != nil {
 		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionvSilos, key, data); err != nil {
+	if err := initStatus(ctx, &silo.Status, &silo.StatusHistory); err != nil {
+		return err
+	}
+	if err := putVirtualSiloState(ctx, &silo); err != nil {
 		return err
 	}
-	userID, _ := ctx.GetClientIdentity().GetID()
-	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
 	return SetHistory(ctx, "AddVirtualSilo", []LogGraph{
 		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
 		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
@@ -722,20 +1039,49 @@ func (s *SmartContract) AddVirtualSilo(ctx contractapi.TransactionContextInterfa
 	}, userID, userMSPID)
 }
 
-func (s *SmartContract) UpdateVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string, SiloData string) error {
-	keyArr := strings.Split(VSiloID, "_")
-	key, err := ctx.GetStub().CreateCompositeKey(vSiloObject, []string{vSiloPrefix, keyArr[0], keyArr[1]})
+// UpdateVirtualSilo replaces a VirtualSilo of the caller with SiloData. The update is
+// rejected with CONFLICT unless expectedVersion is the current version of the silo;
+// an expectedVersion of 0 skips the check.
+func (s *SmartContract) UpdateVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string, SiloData string, expectedVersion int) error {
+	current, err := getVirtualSiloState(ctx, VSiloID)
 	if err != nil {
-		return errors.New("Generate key of " + VSiloID + " failed.")
+		return err
 	}
-	data, err := ctx.GetStub().GetPrivateData(CollectionvSilos, key)
-	if err != nil {
+	if err := checkSiloAccess(ctx, current); err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_VSILO, VSiloID, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	var silo VirtualSilo
+	if err := decodeStrict(NODE_VSILO, VSiloID, SiloData, &silo); err != nil {
 		return err
 	}
-	if data == nil {
-		return errors.New("Update VirtualSilo fails - VirtualSilo " + VSiloID + " not exist")
+	if err := validateVirtualSilo(VSiloID, &silo); err != nil {
+		return err
+	}
+	if silo.FlavourID != "" && current.FlavourID != "" && silo.FlavourID != current.FlavourID {
+		return invalidArgument(NODE_VSILO, VSiloID, "flavourID", "flavourID of a VirtualSilo cannot be changed")
+	}
+	if silo.FlavourID == "" {
+		silo.FlavourID = current.FlavourID
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionvSilos, key, json.RawMessage(SiloData)); err != nil {
+	if (silo.Owner != "" && silo.Owner != current.Owner) || (silo.OwnerMSPID != "" && silo.OwnerMSPID != current.OwnerMSPID) {
+		return invalidArgument(NODE_VSILO, VSiloID, "owner", "owner of a VirtualSilo cannot be changed")
+	}
+	silo.Owner = current.Owner
+	silo.OwnerMSPID = current.OwnerMSPID
+	if err := checkStatusUnchanged(NODE_VSILO, VSiloID, current.Status, silo.Status); err != nil {
+		return err
+	}
+	silo.CreationTime = current.CreationTime
+	silo.Version = current.Version
+	if err := takeSecret(ctx, NODE_VSILO, VSiloID, &silo.FlavourParams, &silo.FlavourParamsSalt, current.FlavourParams, current.FlavourParamsSalt); err != nil {
+		return err
+	}
+	silo.Status = current.Status
+	silo.StatusHistory = current.StatusHistory
+	if err := putVirtualSiloState(ctx, &silo); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -748,141 +1094,431 @@ func (s *SmartContract) UpdateVirtualSilo(ctx contractapi.TransactionContextInte
 }
 
 func (s *SmartContract) GetAllVirtualSilos(ctx contractapi.TransactionContextInterface) ([]VirtualSilo, error) {
-	siloIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionvSilos, "", "")
+	collection, err := callerCollection(ctx)
 	if err != nil {
 		return nil, err
 	}
+	siloIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vSiloObject, []string{vSiloPrefix})
+	if err != nil {
+		return nil, internalError(NODE_VSILO, "", err)
+	}
 	var results []VirtualSilo
 	for siloIterator.HasNext() {
 		queryResponse, err := siloIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VSILO, "", err)
 		}
 		var silo VirtualSilo
 		err = json.Unmarshal(queryResponse.Value, &silo)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VSILO, "", err)
+		}
+		if !canAccessSilo(ctx, silo.Owner) {
+			continue
 		}
 		results = append(results, silo)
 	}
 	err = siloIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VSILO, "", err)
 	}
 	return results, nil
 }
 
 func (s *SmartContract) GetVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string) (*VirtualSilo, error) {
-	keyArr := strings.Split(VSiloID, "_")
-	key, err := ctx.GetStub().CreateCompositeKey(vSiloObject, []string{vSiloPrefix, keyArr[0], keyArr[1]})
-	if err != nil {
-		return nil, errors.New("Generate key of " + VSiloID + " failed.")
-	}
-	byteData, err := ctx.GetStub().GetPrivateData(CollectionvSilos, key)
-	var silo VirtualSilo
+	silo, err := getVirtualSiloState(ctx, VSiloID)
 	if err != nil {
 		return nil, err
 	}
-	if byteData == nil {
-		return nil, errors.New("Get VirtualSilo fails - VirtualSilo " + VSiloID + " not exist")
-	}
-	err = json.Unmarshal(byteData, &silo)
-	if err != nil {
+	if err := checkSiloAccess(ctx, silo); err != nil {
 		return nil, err
 	}
-	return &silo, nil
+	return silo, nil
 }
 
 func (s *SmartContract) GetVirtualSilosByTenantID(ctx contractapi.TransactionContextInterface, TenantID string) ([]VirtualSilo, error) {
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvSilos, vSiloObject, []string{vSiloPrefix, TenantID})
+	collection, err := callerCollection(ctx)
 	if err != nil {
 		return nil, err
 	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vSiloObject, []string{vSiloPrefix, TenantID})
+	if err != nil {
+		return nil, internalError(NODE_VSILO, "", err)
+	}
 	var results []VirtualSilo
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VSILO, "", err)
 		}
 		var vSilo VirtualSilo
 		err = json.Unmarshal(queryResponse.Value, &vSilo)
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VSILO, "", err)
+		}
+		if !canAccessSilo(ctx, vSilo.Owner) {
+			continue
 		}
 		results = append(results, vSilo)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VSILO, "", err)
 	}
 	return results, nil
 }
 
-func (s *SmartContract) DeleteVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string) error {
-	keyArr := strings.Split(VSiloID, "_")
+// VirtualSiloDeletion summarizes what DeleteVirtualSilo removed from the ledger
+type VirtualSiloDeletion struct {
+	VSiloID   string   `json:"vSiloID"`
+	FlavourID string   `json:"flavourID"`
+	VThingIDs []string `json:"vThingIDs"`
+}
+
+// DeleteVirtualSilo removes a VirtualSilo and every vThing binding of it
+func (s *SmartContract) DeleteVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string) (*VirtualSiloDeletion, error) {
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return nil, err
+	}
+	key, err := vSiloKey(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return nil, err
+	}
 	userID, _ := ctx.GetClientIdentity().GetID()
 	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
 	graph := []LogGraph{
 		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
 		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
 		{Source: "tenant-" + userID, Target: "silo-" + VSiloID, SourceType: NODE_USER, TargetType: NODE_DELETED},
-		{Source: "flavour-" + keyArr[1], Target: "silo-" + VSiloID, SourceType: NODE_DELETED, TargetType: NODE_DELETED},
+		{Source: "flavour-" + silo.FlavourID, Target: "silo-" + VSiloID, SourceType: NODE_FLAVOUR, TargetType: NODE_DELETED},
+	}
+	deletion := VirtualSiloDeletion{
+		VSiloID:   VSiloID,
+		FlavourID: silo.FlavourID,
+		VThingIDs: []string{},
 	}
-	args := ctx.GetStub().GetStringArgs()
-	for i := 2; i < len(args); i++ {
-		vThingID := args[i]
-		key, err := ctx.GetStub().CreateCompositeKey(vThingVSiloObject, []string{vThingVSiloPrefix, keyArr[0], keyArr[1], vThingID})
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(implicitCollection(silo.OwnerMSPID), vThingVSiloObject, []string{vThingVSiloPrefix, tenantID, vSiloName})
+	if err != nil {
+		return nil, internalError(NODE_VSILO, VSiloID, err)
+	}
+	var bindingKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return errors.New("Generate key of " + VSiloID + vThingID + " failed.")
+			return nil, internalError(NODE_VSILO, VSiloID, err)
 		}
-		if err := ctx.GetStub().DelPrivateData(CollectionvThingVSilos, key); err != nil {
-			return errors.New("Warning - Delete VThing" + vThingID + " Failed.")
+		var vThingVSilo VThingVSilo
+		err = json.Unmarshal(queryResponse.Value, &vThingVSilo)
+		if err != nil {
+			return nil, internalError(vThingVSiloObject, "", err)
 		}
-		graph = append(graph, LogGraph{Source: "silo-" + VSiloID, Target: "vthing-" + vThingID, SourceType: NODE_DELETED, TargetType: NODE_DELETED})
+		bindingKeys = append(bindingKeys, queryResponse.Key)
+		deletion.VThingIDs = append(deletion.VThingIDs, vThingVSilo.VThingID)
 	}
-	key, err := ctx.GetStub().CreateCompositeKey(vSiloObject, []string{vSiloPrefix, keyArr[0], keyArr[1]})
+	err = resultsIterator.Close()
 	if err != nil {
-		return errors.New("Generate key of " + VSiloID + " failed.")
+		return nil, internalError(NODE_VSILO, VSiloID, err)
 	}
-	if err := ctx.GetStub().DelPrivateData(CollectionvSilos, key); err != nil {
-		return errors.New("Warning - Delete VirtualSilo " + VSiloID + " Failed.")
+	for i, bindingKey := range bindingKeys {
+		vThingID := deletion.VThingIDs[i]
+		if err := deleteBinding(ctx, bindingKey, VSiloID, vThingID, silo.OwnerMSPID); err != nil {
+			return nil, err
+		}
+		graph = append(graph, LogGraph{Source: "silo-" + VSiloID, Target: "vthing-" + vThingID, SourceType: NODE_DELETED, TargetType: NODE_VTHING})
+	}
+	if err := deleteOrgRecord(ctx, CollectionvSilos, key, silo.OwnerMSPID, NODE_VSILO, VSiloID); err != nil {
+		return nil, err
+	}
+	if err := deleteSecretHash(ctx, NODE_VSILO, VSiloID); err != nil {
+		return nil, err
+	}
+	if err := releaseSilo(ctx, tenantID, VSiloID); err != nil {
+		return nil, err
 	}
-	return SetHistory(ctx, "DeleteVirtualSilo", graph, userID, userMSPID)
+	if err := SetHistory(ctx, "DeleteVirtualSilo", graph, userID, userMSPID); err != nil {
+		return nil, err
+	}
+	return &deletion, nil
 }
 
 type VThingVSilo struct {
-	TenantID     string `json:"tenantID"`
-	VSiloID      string `json:"vSiloID"`
-	CreationTime string `json:"creationTime"`
-	VThingID     string `json:"vThingID"`
+	DocType        string            `json:"docType"`
+	TenantID       string            `json:"tenantID"`
+	VSiloID        string            `json:"vSiloID"`
+	CreationTime   string            `json:"creationTime"`
+	VThingID       string            `json:"vThingID"`
+	Owner          string            `json:"owner"`
+	OwnerMSPID     string            `json:"ownerMSPID"`
+	AgreementID    string            `json:"agreementID,omitempty"`
+	SubscriptionID string            `json:"subscriptionID,omitempty"`
+	ValidFrom      string            `json:"validFrom,omitempty"`
+	ValidUntil     string            `json:"validUntil,omitempty"`
+	Policy         *PolicyEvaluation `json:"policy,omitempty"`
+	Version        int               `json:"version"`
+	LastModified   string            `json:"lastModified"`
+	LastModifiedBy string            `json:"lastModifiedBy"`
+}
+
+func vThingVSiloKey(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) (string, error) {
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return "", err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(vThingVSiloObject, []string{vThingVSiloPrefix, tenantID, vSiloName, VThingID})
+	if err != nil {
+		return "", internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
+	}
+	return key, nil
+}
+
+// bindingPlan holds what AddVThingVSilo checked before writing a binding
+type bindingPlan struct {
+	key           string
+	silo          *VirtualSilo
+	thingVisorID  string
+	providerMSPID string
+	agreement     *DataSharingAgreement
+	subscription  *SubscriptionRequest
+	evaluation    PolicyEvaluation
+	replaced      *VThingVSilo
+	binding       VThingVSilo
+}
+
+// planBinding runs every check of AddVThingVSilo without writing anything
+func planBinding(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string, validFrom string, validUntil string, purpose string) (*bindingPlan, error) {
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return nil, err
+	}
+	if silo.Status != STATUS_RUNNING {
+		return nil, invalidState(NODE_VSILO, VSiloID, "Add fails - VirtualSilo "+VSiloID+" is not running")
+	}
+	vThing, err := getVThingState(ctx, VThingID)
+	if err != nil {
+		return nil, err
+	}
+	thingVisorID, _, err := parseVThingID(VThingID)
+	if err != nil {
+		return nil, err
+	}
+	thingVisor, err := getThingVisorState(ctx, thingVisorID)
+	if err != nil {
+		return nil, err
+	}
+	if thingVisor.Status != STATUS_RUNNING {
+		return nil, invalidState(NODE_THINGVISOR, thingVisorID, "Add fails - ThingVisor "+thingVisorID+" is not running")
+	}
+	plan := bindingPlan{silo: silo, thingVisorID: thingVisorID, providerMSPID: thingVisor.OwnerMSPID}
+	var offered bool
+	plan.subscription, offered, err = findSubscription(ctx, VSiloID, thingVisorID, VThingID)
+	if err != nil {
+		return nil, err
+	}
+	if offered && plan.subscription == nil {
+		return nil, forbidden(vThingVSiloObject, VSiloID+"/"+VThingID, "vThing "+VThingID+" is offered in the catalog and VirtualSilo "+VSiloID+" has no approved subscription request for it")
+	}
+	if plan.subscription == nil && thingVisor.OwnerMSPID != "" && thingVisor.OwnerMSPID != silo.OwnerMSPID {
+		plan.agreement, err = findAgreement(ctx, thingVisor.OwnerMSPID, silo.OwnerMSPID, vThing.Type)
+		if err != nil {
+			return nil, err
+		}
+		if plan.agreement == nil {
+			return nil, forbidden(vThingVSiloObject, VSiloID+"/"+VThingID, "no active data sharing agreement from "+thingVisor.OwnerMSPID+" to "+silo.OwnerMSPID+" covers vThing "+VThingID+" of type '"+vThing.Type+"'")
+		}
+	}
+	if plan.key, err = vThingVSiloKey(ctx, VSiloID, VThingID); err != nil {
+		return nil, err
+	}
+	if err := parseBindingValidity(ctx, VSiloID+"/"+VThingID, validFrom, validUntil); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan.evaluation = evaluatePolicy(vThing.Policy, policyRequest{
+		"purpose":      purpose,
+		"flavour":      silo.FlavourID,
+		"organization": silo.OwnerMSPID,
+		"tenant":       silo.TenantID,
+		"dateTime":     now,
+	})
+	if plan.evaluation.Decision != POLICY_PERMIT {
+		return nil, forbidden(vThingVSiloObject, VSiloID+"/"+VThingID, "usage policy of vThing "+VThingID+" denies purpose '"+purpose+"' with flavour '"+silo.FlavourID+"': "+plan.evaluation.Reason)
+	}
+	plan.evaluation.Purpose = purpose
+	plan.evaluation.FlavourID = silo.FlavourID
+	plan.evaluation.PolicyVersion = vThing.Version
+	plan.evaluation.EvaluatedAt = now
+	exists, err := getOrgRecord(ctx, CollectionvThingVSilos, plan.key, vThingVSiloObject, VSiloID+"/"+VThingID)
+	if err != nil {
+		return nil, err
+	}
+	if exists != nil {
+		var existing VThingVSilo
+		if err := json.Unmarshal(exists, &existing); err != nil {
+			return nil, internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
+		}
+		timestamp, err := txTimestamp(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !existing.expired(timestamp) {
+			return nil, alreadyExists(vThingVSiloObject, VSiloID+"/"+VThingID)
+		}
+		plan.replaced = &existing
+	}
+	plan.binding = VThingVSilo{
+		TenantID:     silo.TenantID,
+		VSiloID:      VSiloID,
+		CreationTime: now,
+		VThingID:     VThingID,
+		Owner:        silo.Owner,
+		OwnerMSPID:   silo.OwnerMSPID,
+		ValidFrom:    validFrom,
+		ValidUntil:   validUntil,
+		Policy:       &plan.evaluation,
+		Version:      1,
+	}
+	if plan.agreement != nil {
+		plan.binding.AgreementID = plan.agreement.AgreementID
+	}
+	if plan.subscription != nil {
+		plan.binding.SubscriptionID = plan.subscription.RequestID
+	}
+	return &plan, nil
+}
+
+// writeBinding writes a planned binding, replacing an expired one, opens its usage
+// interval and returns its graph edges. The caller reserves it in the quota.
+func writeBinding(ctx contractapi.TransactionContextInterface, plan *bindingPlan) ([]LogGraph, error) {
+	binding := &plan.binding
+	id := binding.VSiloID + "/" + binding.VThingID
+	if plan.replaced != nil {
+		if err := deleteBinding(ctx, plan.key, binding.VSiloID, binding.VThingID, plan.replaced.OwnerMSPID); err != nil {
+			return nil, err
+		}
+	}
+	graph := []LogGraph{
+		{Source: "silo-" + binding.VSiloID, Target: "vthing-" + binding.VThingID, SourceType: NODE_VSILO, TargetType: NODE_VTHING},
+	}
+	if plan.agreement != nil {
+		graph = append(graph, LogGraph{Source: "agreement-" + plan.agreement.AgreementID, Target: "silo-" + binding.VSiloID, SourceType: NODE_AGREEMENT, TargetType: NODE_VSILO})
+	}
+	if plan.subscription != nil {
+		graph = append(graph, LogGraph{Source: "subscription-" + plan.subscription.RequestID, Target: "silo-" + binding.VSiloID, SourceType: NODE_SUBSCRIPTION, TargetType: NODE_VSILO})
+	}
+	if err := setLastModified(ctx, &binding.LastModified, &binding.LastModifiedBy); err != nil {
+		return nil, err
+	}
+	binding.DocType = vThingVSiloObject
+	data, err := json.Marshal(binding)
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, id, err)
+	}
+	entry := OrgIndexEntry{VSiloID: binding.VSiloID, VThingID: binding.VThingID, OwnerMSPID: binding.OwnerMSPID}
+	if err := putOrgRecord(ctx, CollectionvThingVSilos, plan.key, entry, data); err != nil {
+		return nil, internalError(vThingVSiloObject, id, err)
+	}
+	if err := openUsageInterval(ctx, binding, plan.thingVisorID, plan.providerMSPID); err != nil {
+		return nil, err
+	}
+	return graph, nil
 }
 
-func (s *SmartContract) AddVThingVSilo(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string, Data string) error {
-	keyArr := strings.Split(VSiloID, "_")
-	key, err := ctx.GetStub().CreateCompositeKey(vThingVSiloObject, []string{vThingVSiloPrefix, keyArr[0], keyArr[1], VThingID})
+// AddVThingVSilo binds a vThing to a running VirtualSilo. The vThing must exist
+// and its ThingVisor must be running. A vThing in an open catalog offer needs an
+// approved subscription request of the silo; otherwise a vThing of a ThingVisor of
+// another organization needs an active DataSharingAgreement covering its type.
+// validFrom and validUntil optionally bound the binding in RFC 3339; the getters
+// only return it within those bounds, and an expired binding of the vThing is
+// replaced. The usage policy of the vThing is evaluated for the purpose declared by
+// the tenant and the flavour of the silo, and the result is kept in the binding.
+func (s *SmartContract) AddVThingVSilo(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string, validFrom string, validUntil string, purpose string) error {
+	plan, err := planBinding(ctx, VSiloID, VThingID, validFrom, validUntil, purpose)
 	if err != nil {
-		return errors.New("Generate key of " + VSiloID + VThingID + " failed.")
+		return err
 	}
-	if err := ctx.GetStub().PutPrivateData(CollectionvThingVSilos, key, json.RawMessage(Data)); err != nil {
+	if plan.replaced == nil {
+		if err := reserveVThings(ctx, plan.silo.TenantID, VSiloID, 1); err != nil {
+			return err
+		}
+	}
+	bindingGraph, err := writeBinding(ctx, plan)
+	if err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
 	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
-	return SetHistory(ctx, "AddVThingVSilo", []LogGraph{
+	graph := []LogGraph{
 		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
 		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
 		{Source: "tenant-" + userID, Target: "silo-" + VSiloID, SourceType: NODE_USER, TargetType: NODE_VSILO},
-		{Source: "silo-" + VSiloID, Target: "vthing-" + VThingID, SourceType: NODE_VSILO, TargetType: NODE_VTHING},
-	}, userID, userMSPID)
+	}
+	return SetHistory(ctx, "AddVThingVSilo", append(graph, bindingGraph...), userID, userMSPID)
+}
+
+// deleteBinding removes a binding and its index entry and closes its usage interval.
+// The caller releases the binding from the quota of the tenant.
+func deleteBinding(ctx contractapi.TransactionContextInterface, key string, VSiloID string, VThingID string, ownerMSPID string) error {
+	if err := deleteOrgRecord(ctx, CollectionvThingVSilos, key, ownerMSPID, vThingVSiloObject, VSiloID+"/"+VThingID); err != nil {
+		return err
+	}
+	return closeUsageInterval(ctx, VSiloID, VThingID)
+}
+
+// releaseBindings releases deleted bindings, given by VirtualSilo ID, from the quotas
+func releaseBindings(ctx contractapi.TransactionContextInterface, VSiloIDs []string) error {
+	counts := map[string]int{}
+	var silos []string
+	for _, VSiloID := range VSiloIDs {
+		if counts[VSiloID] == 0 {
+			silos = append(silos, VSiloID)
+		}
+		counts[VSiloID]++
+	}
+	for _, VSiloID := range silos {
+		if err := releaseVThings(ctx, VSiloID, counts[VSiloID]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *SmartContract) DeleteVThingVSilo(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) error {
-	keyArr := strings.Split(VSiloID, "_")
-	key, err := ctx.GetStub().CreateCompositeKey(vThingVSiloObject, []string{vThingVSiloPrefix, keyArr[0], keyArr[1], VThingID})
+	silo, err := getVirtualSiloState(ctx, VSiloID)
 	if err != nil {
-		return errors.New("Generate key of " + VSiloID + VThingID + " failed.")
+		return err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return err
 	}
-	if err := ctx.GetStub().DelPrivateData(CollectionvThingVSilos, key); err != nil {
+	key, err := vThingVSiloKey(ctx, VSiloID, VThingID)
+	if err != nil {
+		return err
+	}
+	binding, err := getOrgRecord(ctx, CollectionvThingVSilos, key, vThingVSiloObject, VSiloID+"/"+VThingID)
+	if err != nil {
+		return err
+	}
+	if binding == nil {
+		return notFound(vThingVSiloObject, VSiloID+"/"+VThingID)
+	}
+	if err := deleteBinding(ctx, key, VSiloID, VThingID, silo.OwnerMSPID); err != nil {
+		return err
+	}
+	if err := releaseVThings(ctx, VSiloID, 1); err != nil {
 		return err
 	}
 	userID, _ := ctx.GetClientIdentity().GetID()
@@ -896,78 +1532,123 @@ func (s *SmartContract) DeleteVThingVSilo(ctx contractapi.TransactionContextInte
 }
 
 func (s *SmartContract) GetVThingVSilosByVSiloID(ctx contractapi.TransactionContextInterface, VSiloID string) ([]VThingVSilo, error) {
-	keyArr := strings.Split(VSiloID, "_")
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingVSilos, vThingVSiloObject, []string{vThingVSiloPrefix, keyArr[0], keyArr[1]})
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	silo, err := getVirtualSiloState(ctx, VSiloID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return nil, err
+	}
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(implicitCollection(silo.OwnerMSPID), vThingVSiloObject, []string{vThingVSiloPrefix, tenantID, vSiloName})
+	if err != nil {
+		return nil, internalError(NODE_VSILO, VSiloID, err)
+	}
 	var results []VThingVSilo
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(NODE_VSILO, VSiloID, err)
 		}
 		var vThingVSilo VThingVSilo
 		err = json.Unmarshal(queryResponse.Value, &vThingVSilo)
 		if err != nil {
-			return nil, err
+			return nil, internalError(vThingVSiloObject, "", err)
+		}
+		if !canAccessSilo(ctx, vThingVSilo.Owner) || !vThingVSilo.active(now) {
+			continue
 		}
 		results = append(results, vThingVSilo)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(NODE_VSILO, VSiloID, err)
 	}
 	return results, nil
 }
 
 func (s *SmartContract) GetVThingVSilosByTenantID(ctx contractapi.TransactionContextInterface, TenantID string) ([]VThingVSilo, error) {
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingVSilos, vThingVSiloObject, []string{vThingVSiloPrefix, TenantID})
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := callerCollection(ctx)
 	if err != nil {
 		return nil, err
 	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vThingVSiloObject, []string{vThingVSiloPrefix, TenantID})
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, "", err)
+	}
 	var results []VThingVSilo
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(vThingVSiloObject, "", err)
 		}
 		var vThingVSilo VThingVSilo
 		err = json.Unmarshal(queryResponse.Value, &vThingVSilo)
 		if err != nil {
-			return nil, err
+			return nil, internalError(vThingVSiloObject, "", err)
+		}
+		if !canAccessSilo(ctx, vThingVSilo.Owner) || !vThingVSilo.active(now) {
+			continue
 		}
 		results = append(results, vThingVSilo)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(vThingVSiloObject, "", err)
 	}
 	return results, nil
 }
 
 func (s *SmartContract) GetVThingVSilo(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) ([]VThingVSilo, error) {
-	keyArr := strings.Split(VSiloID, "_")
-	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingVSilos, vThingVSiloObject, []string{vThingVSiloPrefix, keyArr[0], keyArr[1], VThingID})
+	now, err := txTimestamp(ctx)
 	if err != nil {
 		return nil, err
 	}
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return nil, err
+	}
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(implicitCollection(silo.OwnerMSPID), vThingVSiloObject, []string{vThingVSiloPrefix, tenantID, vSiloName, VThingID})
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
+	}
 	var results []VThingVSilo
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return nil, internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
 		}
 		var vThingVSilo VThingVSilo
 		err = json.Unmarshal(queryResponse.Value, &vThingVSilo)
 		if err != nil {
-			return nil, err
+			return nil, internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
+		}
+		if !vThingVSilo.active(now) {
+			continue
 		}
 		results = append(results, vThingVSilo)
 	}
 	err = resultsIterator.Close()
 	if err != nil {
-		return nil, err
+		return nil, internalError(vThingVSiloObject, VSiloID+"/"+VThingID, err)
 	}
 	return results, nil
 }
@@ -979,7 +1660,9 @@ func main() {
 		Address: os.Getenv("CHAINCODE_SERVER_ADDRESS"),
 	}
 
-	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	contract := &SmartContract{}
+	contract.BeforeTransaction = checkFunctionPolicy
+	chaincode, err := contractapi.NewChaincode(contract)
 
 	if err != nil {
 		log.Panicf("error create asset-transfer-basic chaincode: %s", err)
@@ -0,0 +1,217 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MAX_BATCH_SIZE bounds the elements of a batch transaction
+const MAX_BATCH_SIZE int = 100
+
+// BindingRequest is an element of AddVThingVSiloBatch, with the arguments of AddVThingVSilo
+type BindingRequest struct {
+	VSiloID    string `json:"vSiloID"`
+	VThingID   string `json:"vThingID"`
+	ValidFrom  string `json:"validFrom,omitempty"`
+	ValidUntil string `json:"validUntil,omitempty"`
+	Purpose    string `json:"purpose,omitempty"`
+}
+
+func checkBatchSize(kind string, id string, size int) error {
+	if size == 0 || size > MAX_BATCH_SIZE {
+		return invalidArgument(kind, id, "", "a batch must hold between 1 and "+strconv.Itoa(MAX_BATCH_SIZE)+" elements")
+	}
+	return nil
+}
+
+// VThingsAddition lists the IDs of the vThings that AddVThingsToThingVisor created
+// and of those that already existed and were replaced
+type VThingsAddition struct {
+	Added    []string `json:"added"`
+	Replaced []string `json:"replaced"`
+}
+
+// AddVThingsToThingVisor adds or replaces, like AddVThingToThingVisor, every vThing of
+// a JSON array. Nothing is written unless all of them are valid.
+func (s *SmartContract) AddVThingsToThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingsData string) (*VThingsAddition, error) {
+	thingVisor, err := getThingVisorState(ctx, ThingVisorID)
+	if err != nil {
+		return nil, err
+	}
+	if thingVisor.Status != STATUS_RUNNING {
+		return nil, invalidState(NODE_THINGVISOR, ThingVisorID, "Add fails - ThingVisor "+ThingVisorID+" is not running")
+	}
+	var vThings []VThingTV
+	if err := decodeStrict(NODE_VTHING, "", vThingsData, &vThings); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(NODE_VTHING, "", len(vThings)); err != nil {
+		return nil, err
+	}
+	addition := VThingsAddition{Added: []string{}, Replaced: []string{}}
+	seen := map[string]bool{}
+	for i := range vThings {
+		vThing := &vThings[i]
+		if err := validateVThing(ThingVisorID, vThing); err != nil {
+			return nil, err
+		}
+		if seen[vThing.ID] {
+			return nil, invalidArgument(NODE_VTHING, vThing.ID, "id", "vThing "+vThing.ID+" is listed more than once")
+		}
+		seen[vThing.ID] = true
+		vThing.Version = 0
+		current, err := getVThingState(ctx, vThing.ID)
+		if err == nil {
+			vThing.Version = current.Version
+			addition.Replaced = append(addition.Replaced, vThing.ID)
+			continue
+		}
+		if e, ok := err.(*ChaincodeError); !ok || e.Code != CODE_NOT_FOUND {
+			return nil, err
+		}
+		addition.Added = append(addition.Added, vThing.ID)
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+	}
+	for i := range vThings {
+		if err := putVThingState(ctx, &vThings[i]); err != nil {
+			return nil, err
+		}
+		graph = append(graph, LogGraph{Source: "thingvisor-" + ThingVisorID, Target: "vthing-" + vThings[i].ID, SourceType: NODE_THINGVISOR, TargetType: NODE_VTHING})
+	}
+	if err := SetHistory(ctx, "AddVThingsToThingVisor", graph, userID, userMSPID); err != nil {
+		return nil, err
+	}
+	return &addition, nil
+}
+
+// DeleteVThingsFromThingVisor removes the vThings whose IDs are given as a JSON array.
+// Nothing is removed unless all of them exist. Bound vThings are handled as in
+// DeleteVThingFromThingVisor.
+func (s *SmartContract) DeleteVThingsFromThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, vThingIDs string, force bool) error {
+	thingVisor, err := getThingVisorState(ctx, ThingVisorID)
+	if err != nil {
+		return err
+	}
+	if thingVisor.Status != STATUS_RUNNING {
+		return invalidState(NODE_THINGVISOR, ThingVisorID, "Delete fails - ThingVisor "+ThingVisorID+" is not running")
+	}
+	var IDs []string
+	if err := decodeStrict(NODE_VTHING, "", vThingIDs, &IDs); err != nil {
+		return err
+	}
+	if err := checkBatchSize(NODE_VTHING, "", len(IDs)); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	var keys []string
+	for _, VThingID := range IDs {
+		if err := validateVThing(ThingVisorID, &VThingTV{ID: VThingID}); err != nil {
+			return err
+		}
+		if seen[VThingID] {
+			return invalidArgument(NODE_VTHING, VThingID, "id", "vThing "+VThingID+" is listed more than once")
+		}
+		seen[VThingID] = true
+		if _, err := getVThingState(ctx, VThingID); err != nil {
+			return err
+		}
+		key, err := vThingTVKey(ctx, VThingID)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	detachGraph, err := detachVThings(ctx, NODE_THINGVISOR, ThingVisorID, ThingVisorID, IDs, force)
+	if err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+	}
+	graph = append(graph, detachGraph...)
+	for i, key := range keys {
+		if err := deletePrivateAsset(ctx, CollectionvThingTVs, key); err != nil {
+			return internalError(NODE_VTHING, IDs[i], err)
+		}
+		graph = append(graph, LogGraph{Source: "thingvisor-" + ThingVisorID, Target: "vthing-" + IDs[i], SourceType: NODE_THINGVISOR, TargetType: NODE_DELETED})
+	}
+	return SetHistory(ctx, "DeleteVThingsFromThingVisor", graph, userID, userMSPID)
+}
+
+// AddVThingVSiloBatch makes every binding of a JSON array of BindingRequest, e.g.
+// [{"vSiloID":"tenant1_silo1","vThingID":"weather/Tokyo","purpose":"research"}],
+// with the checks of AddVThingVSilo. Nothing is bound unless all of them pass.
+func (s *SmartContract) AddVThingVSiloBatch(ctx contractapi.TransactionContextInterface, bindingsData string) error {
+	var requests []BindingRequest
+	if err := decodeStrict(vThingVSiloObject, "", bindingsData, &requests); err != nil {
+		return err
+	}
+	if err := checkBatchSize(vThingVSiloObject, "", len(requests)); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	plans := make([]*bindingPlan, 0, len(requests))
+	// counters are reserved once per silo, since a transaction does not read its own writes
+	tenants := map[string]string{}
+	reserved := map[string]int{}
+	var silos []string
+	for _, request := range requests {
+		id := request.VSiloID + "/" + request.VThingID
+		if seen[id] {
+			return invalidArgument(vThingVSiloObject, id, "", "binding "+id+" is listed more than once")
+		}
+		seen[id] = true
+		plan, err := planBinding(ctx, request.VSiloID, request.VThingID, request.ValidFrom, request.ValidUntil, request.Purpose)
+		if err != nil {
+			return err
+		}
+		if _, ok := tenants[request.VSiloID]; !ok {
+			tenants[request.VSiloID] = plan.silo.TenantID
+			silos = append(silos, request.VSiloID)
+		}
+		if plan.replaced == nil {
+			reserved[request.VSiloID]++
+		}
+		plans = append(plans, plan)
+	}
+	for _, VSiloID := range silos {
+		if reserved[VSiloID] == 0 {
+			continue
+		}
+		if err := reserveVThings(ctx, tenants[VSiloID], VSiloID, reserved[VSiloID]); err != nil {
+			return err
+		}
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := []LogGraph{
+		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+	}
+	for _, VSiloID := range silos {
+		graph = append(graph, LogGraph{Source: "tenant-" + userID, Target: "silo-" + VSiloID, SourceType: NODE_USER, TargetType: NODE_VSILO})
+	}
+	for _, plan := range plans {
+		bindingGraph, err := writeBinding(ctx, plan)
+		if err != nil {
+			return err
+		}
+		graph = append(graph, bindingGraph...)
+	}
+	return SetHistory(ctx, "AddVThingVSiloBatch", graph, userID, userMSPID)
+}
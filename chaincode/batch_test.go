@@ -0,0 +1,194 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func siloVThings(ctx contractapi.TransactionContextInterface, VSiloID string) int {
+	key, _ := siloVThingsKey(ctx, VSiloID)
+	count, _ := getCounter(ctx, key)
+	return count
+}
+
+func TestAddVThingVSiloBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindings string
+		max      int
+		code     string
+		bound    map[string]int
+	}{
+		{
+			name:     "bindings of one ThingVisor and tenant",
+			bindings: `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_a","vThingID":"weather/Osaka"},{"vSiloID":"tenant1_b","vThingID":"weather/Tokyo","purpose":"research"}]`,
+			bound:    map[string]int{"tenant1_a": 2, "tenant1_b": 1},
+		},
+		{name: "empty batch", bindings: `[]`, code: CODE_INVALID_ARGUMENT},
+		{name: "listed twice", bindings: `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"}]`, code: CODE_INVALID_ARGUMENT},
+		{name: "missing vThing", bindings: `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_a","vThingID":"weather/Kyoto"}]`, code: CODE_NOT_FOUND},
+		{name: "missing silo", bindings: `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_c","vThingID":"weather/Tokyo"}]`, code: CODE_NOT_FOUND},
+		{name: "over the quota", bindings: `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_a","vThingID":"weather/Osaka"}]`, max: 1, code: CODE_FORBIDDEN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+			putTestVThings(t, ctx, "weather", "Org1MSP", "Tokyo", "Osaka")
+			putTestSilo(t, ctx, "tenant1_a", "Org1MSP")
+			putTestSilo(t, ctx, "tenant1_b", "Org1MSP")
+			setTestQuota(t, ctx, TenantQuota{TenantID: "tenant1", MaxVThingsPerSilo: tt.max})
+			stub.MockTransactionEnd("tx0")
+			before := stub.privateKeys()
+			err := stub.invoke("tx1", testTime+60, func() error {
+				return (&SmartContract{}).AddVThingVSiloBatch(ctx, tt.bindings)
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				if after := stub.privateKeys(); !reflect.DeepEqual(after, before) {
+					t.Errorf("private keys = %v, want %v", after, before)
+				}
+				return
+			}
+			if !reflect.DeepEqual(stub.events, []string{"AddVThingVSiloBatch"}) {
+				t.Errorf("events = %v", stub.events)
+			}
+			intervalIDs := map[string]bool{}
+			for _, interval := range usageIntervals(t, ctx, "weather") {
+				if interval.TenantID != "tenant1" || interval.End != "" {
+					t.Errorf("interval = %+v", interval)
+				}
+				intervalIDs[interval.IntervalID] = true
+			}
+			if len(intervalIDs) != 3 {
+				t.Errorf("interval IDs = %v, want one per binding", intervalIDs)
+			}
+			for VSiloID, count := range tt.bound {
+				if got := siloVThings(ctx, VSiloID); got != count {
+					t.Errorf("vThings of %s = %d, want %d", VSiloID, got, count)
+				}
+			}
+			for _, VThingID := range []string{"weather/Tokyo", "weather/Osaka"} {
+				interval, err := getOpenUsageInterval(ctx, "tenant1_a", VThingID)
+				if err != nil || interval == nil || interval.IntervalID != "tx1/tenant1_a/"+VThingID {
+					t.Errorf("open interval of %s = %+v, %v", VThingID, interval, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteVThingsFromThingVisor(t *testing.T) {
+	tests := []struct {
+		name     string
+		vThings  string
+		force    bool
+		foreign  bool
+		code     string
+		detached int
+	}{
+		{name: "unbound vThing", vThings: `["weather/Kyoto"]`},
+		{name: "bound vThing", vThings: `["weather/Tokyo","weather/Kyoto"]`, code: CODE_INVALID_STATE},
+		{name: "bound vThing with force", vThings: `["weather/Tokyo","weather/Osaka"]`, force: true, detached: 3},
+		{name: "binding of another organization", vThings: `["weather/Tokyo"]`, force: true, foreign: true, code: CODE_INVALID_STATE},
+		{name: "missing vThing", vThings: `["weather/Tokyo","weather/Nara"]`, force: true, code: CODE_NOT_FOUND},
+		{name: "vThing of another ThingVisor", vThings: `["traffic/Tokyo"]`, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			consumer := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+			putTestVThings(t, consumer, "weather", "Org1MSP", "Tokyo", "Osaka", "Kyoto")
+			putTestSilo(t, consumer, "tenant1_a", "Org1MSP")
+			putTestSilo(t, consumer, "tenant1_b", "Org1MSP")
+			stub.MockTransactionEnd("tx0")
+			bindings := `[{"vSiloID":"tenant1_a","vThingID":"weather/Tokyo"},{"vSiloID":"tenant1_a","vThingID":"weather/Osaka"},{"vSiloID":"tenant1_b","vThingID":"weather/Tokyo"}]`
+			err := stub.invoke("tx1", testTime+60, func() error {
+				if err := (&SmartContract{}).AddVThingVSiloBatch(consumer, bindings); err != nil || !tt.foreign {
+					return err
+				}
+				key, _ := vThingVSiloKey(consumer, "tenant2_a", "weather/Tokyo")
+				data, _ := json.Marshal(VThingVSilo{TenantID: "tenant2", VSiloID: "tenant2_a", VThingID: "weather/Tokyo", OwnerMSPID: "Org2MSP"})
+				entry := OrgIndexEntry{VSiloID: "tenant2_a", VThingID: "weather/Tokyo", OwnerMSPID: "Org2MSP"}
+				return putOrgRecord(consumer, CollectionvThingVSilos, key, entry, data)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := newTestContext(stub, "provider1", "Org1MSP", ROLE_PROVIDER)
+			err = stub.invoke("tx2", testTime+120, func() error {
+				return (&SmartContract{}).DeleteVThingsFromThingVisor(provider, "weather", tt.vThings, tt.force)
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				return
+			}
+			var IDs []string
+			_ = json.Unmarshal([]byte(tt.vThings), &IDs)
+			for _, VThingID := range IDs {
+				if _, err := getVThingState(provider, VThingID); errorCode(err) != CODE_NOT_FOUND {
+					t.Errorf("vThing %s was not deleted (%v)", VThingID, err)
+				}
+			}
+			closed := 0
+			for _, interval := range usageIntervals(t, provider, "weather") {
+				if interval.End != "" {
+					closed++
+				}
+			}
+			if closed != tt.detached {
+				t.Errorf("closed intervals = %d, want %d", closed, tt.detached)
+			}
+			if tt.detached > 0 && (siloVThings(provider, "tenant1_a") != 0 || siloVThings(provider, "tenant1_b") != 0) {
+				t.Errorf("vThings = %d and %d, want the detached bindings released", siloVThings(provider, "tenant1_a"), siloVThings(provider, "tenant1_b"))
+			}
+			if !reflect.DeepEqual(stub.events, []string{"DeleteVThingsFromThingVisor"}) {
+				t.Errorf("events = %v", stub.events)
+			}
+			var history History
+			if err := json.Unmarshal(stub.payload, &history); err != nil {
+				t.Fatal(err)
+			}
+			userEdge := LogGraph{Source: "user-provider1", Target: "thingvisor-weather", SourceType: NODE_USER, TargetType: NODE_THINGVISOR}
+			if len(history.LogGraphs) < 3 || history.LogGraphs[2] != userEdge {
+				t.Errorf("graph = %+v, want %+v after the caller edges", history.LogGraphs, userEdge)
+			}
+		})
+	}
+}
+
+func TestAddVThingsToThingVisor(t *testing.T) {
+	stub := newTestStub()
+	provider := newTestContext(stub, "provider2", "Org2MSP", ROLE_PROVIDER)
+	putTestVThings(t, provider, "rail", "Org2MSP", "Umeda")
+	stub.MockTransactionEnd("tx0")
+	vThings := `[{"id":"rail/Umeda","label":"Umeda","type":"crowd"},{"id":"rail/Namba","label":"Namba","type":"crowd"}]`
+	var addition *VThingsAddition
+	if err := stub.invoke("tx1", testTime+60, func() error {
+		var err error
+		addition, err = (&SmartContract{}).AddVThingsToThingVisor(provider, "rail", vThings)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&VThingsAddition{Added: []string{"rail/Namba"}, Replaced: []string{"rail/Umeda"}}); !reflect.DeepEqual(addition, want) {
+		t.Errorf("addition = %+v, want %+v", addition, want)
+	}
+	for VThingID, version := range map[string]int{"rail/Umeda": 2, "rail/Namba": 1} {
+		vThing, err := getVThingState(provider, VThingID)
+		if err != nil || vThing.Type != "crowd" || vThing.Version != version {
+			t.Errorf("vThing %s = %+v, %v, want type crowd at version %d", VThingID, vThing, err, version)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	CollectionCatalog string = "collectionCatalog"
+
+	offerObject            string = "offer"
+	subscriptionObject     string = "subscription"
+	siloSubscriptionObject string = "siloSubscription"
+
+	STATUS_WITHDRAWN string = "withdrawn"
+	STATUS_APPROVED  string = "approved"
+	STATUS_REJECTED  string = "rejected"
+
+	NODE_OFFER        string = "offer"
+	NODE_SUBSCRIPTION string = "subscription"
+)
+
+// Offer publishes the terms under which a provider lets silos bind a vThing, or every
+// vThing of a ThingVisor when VThingID is empty. An empty AllowedMSPIDs admits every
+// organization; ValidFrom and ValidUntil are optional RFC 3339 bounds.
+type Offer struct {
+	OfferID        string   `json:"offerID"`
+	ThingVisorID   string   `json:"thingVisorID"`
+	VThingID       string   `json:"vThingID"`
+	ProviderMSPID  string   `json:"providerMSPID"`
+	Price          string   `json:"price"`
+	Currency       string   `json:"currency"`
+	Licence        string   `json:"licence"`
+	AllowedMSPIDs  []string `json:"allowedMSPIDs"`
+	ValidFrom      string   `json:"validFrom"`
+	ValidUntil     string   `json:"validUntil"`
+	Status         string   `json:"status"`
+	CreatedBy      string   `json:"createdBy"`
+	CreationTime   string   `json:"creationTime"`
+	Version        int      `json:"version"`
+	LastModified   string   `json:"lastModified"`
+	LastModifiedBy string   `json:"lastModifiedBy"`
+}
+
+// SubscriptionRequest asks the provider of an offer to let a VirtualSilo bind the
+// vThings of the offer. Once approved, AddVThingVSilo accepts them for the silo.
+type SubscriptionRequest struct {
+	RequestID      string `json:"requestID"`
+	OfferID        string `json:"offerID"`
+	VSiloID        string `json:"vSiloID"`
+	TenantID       string `json:"tenantID"`
+	ProviderMSPID  string `json:"providerMSPID"`
+	ConsumerMSPID  string `json:"consumerMSPID"`
+	RequestedBy    string `json:"requestedBy"`
+	Status         string `json:"status"`
+	DecidedBy      string `json:"decidedBy"`
+	Reason         string `json:"reason"`
+	CreationTime   string `json:"creationTime"`
+	Version        int    `json:"version"`
+	LastModified   string `json:"lastModified"`
+	LastModifiedBy string `json:"lastModifiedBy"`
+}
+
+func catalogKey(ctx contractapi.TransactionContextInterface, objectType string, kind string, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return "", internalError(kind, id, err)
+	}
+	return key, nil
+}
+
+func getOfferState(ctx contractapi.TransactionContextInterface, offerID string) (*Offer, error) {
+	key, err := catalogKey(ctx, offerObject, NODE_OFFER, offerID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ctx.GetStub().GetPrivateData(CollectionCatalog, key)
+	if err != nil {
+		return nil, internalError(NODE_OFFER, offerID, err)
+	}
+	if data == nil {
+		return nil, notFound(NODE_OFFER, offerID)
+	}
+	var offer Offer
+	if err := json.Unmarshal(data, &offer); err != nil {
+		return nil, internalError(NODE_OFFER, offerID, err)
+	}
+	return &offer, nil
+}
+
+func putOfferState(ctx contractapi.TransactionContextInterface, offer *Offer) error {
+	if err := setLastModified(ctx, &offer.LastModified, &offer.LastModifiedBy); err != nil {
+		return err
+	}
+	offer.Version++
+	key, err := catalogKey(ctx, offerObject, NODE_OFFER, offer.OfferID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(offer)
+	if err != nil {
+		return internalError(NODE_OFFER, offer.OfferID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionCatalog, key, data); err != nil {
+		return internalError(NODE_OFFER, offer.OfferID, err)
+	}
+	return nil
+}
+
+func getSubscriptionState(ctx contractapi.TransactionContextInterface, requestID string) (*SubscriptionRequest, error) {
+	key, err := catalogKey(ctx, subscriptionObject, NODE_SUBSCRIPTION, requestID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ctx.GetStub().GetPrivateData(CollectionCatalog, key)
+	if err != nil {
+		return nil, internalError(NODE_SUBSCRIPTION, requestID, err)
+	}
+	if data == nil {
+		return nil, notFound(NODE_SUBSCRIPTION, requestID)
+	}
+	var request SubscriptionRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, internalError(NODE_SUBSCRIPTION, requestID, err)
+	}
+	return &request, nil
+}
+
+func putSubscriptionState(ctx contractapi.TransactionContextInterface, request *SubscriptionRequest) error {
+	if err := setLastModified(ctx, &request.LastModified, &request.LastModifiedBy); err != nil {
+		return err
+	}
+	request.Version++
+	key, err := catalogKey(ctx, subscriptionObject, NODE_SUBSCRIPTION, request.RequestID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return internalError(NODE_SUBSCRIPTION, request.RequestID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionCatalog, key, data); err != nil {
+		return internalError(NODE_SUBSCRIPTION, request.RequestID, err)
+	}
+	return nil
+}
+
+// covers reports whether the offer is for the vThing, or for its whole ThingVisor
+func (o *Offer) covers(thingVisorID string, VThingID string) bool {
+	return o.ThingVisorID == thingVisorID && (o.VThingID == "" || o.VThingID == VThingID)
+}
+
+// isOpen reports whether the offer is active and valid at the time now
+func (o *Offer) isOpen(now time.Time) bool {
+	if o.Status != STATUS_ACTIVE {
+		return false
+	}
+	if o.ValidFrom != "" {
+		validFrom, err := time.Parse(time.RFC3339, o.ValidFrom)
+		if err != nil || now.Before(validFrom) {
+			return false
+		}
+	}
+	if o.ValidUntil != "" {
+		validUntil, err := time.Parse(time.RFC3339, o.ValidUntil)
+		if err != nil || !now.Before(validUntil) {
+			return false
+		}
+	}
+	return true
+}
+
+// isClosed reports whether the offer was withdrawn or its validity ended before the time now
+func (o *Offer) isClosed(now time.Time) bool {
+	if o.Status == STATUS_WITHDRAWN {
+		return true
+	}
+	if o.ValidUntil == "" {
+		return false
+	}
+	validUntil, err := time.Parse(time.RFC3339, o.ValidUntil)
+	return err == nil && !now.Before(validUntil)
+}
+
+// admits reports whether the offer is open to an organization
+func (o *Offer) admits(mspID string) bool {
+	return len(o.AllowedMSPIDs) == 0 || containsString(o.AllowedMSPIDs, mspID)
+}
+
+// visibleTo reports whether an organization can see the offer in the catalog: its
+// provider and the organizations it admits
+func (o *Offer) visibleTo(mspID string) bool {
+	return o.ProviderMSPID == mspID || o.admits(mspID)
+}
+
+// newerThan reports whether the offer was published after other. CreationTime only
+// has a precision of a second, so offers published in the same second are ordered
+// by offerID for every peer to pick the same one.
+func (o *Offer) newerThan(other *Offer) bool {
+	created, err := time.Parse(time.RFC3339, o.CreationTime)
+	otherCreated, otherErr := time.Parse(time.RFC3339, other.CreationTime)
+	if err == nil && otherErr == nil && !created.Equal(otherCreated) {
+		return created.After(otherCreated)
+	}
+	return o.OfferID > other.OfferID
+}
+
+// checkOfferProvider only lets the organization that published an offer act on it
+func checkOfferProvider(ctx contractapi.TransactionContextInterface, offer *Offer) error {
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if offer.ProviderMSPID != "" && offer.ProviderMSPID != userMSPID {
+		return forbidden(NODE_OFFER, offer.OfferID, "offer "+offer.OfferID+" is published by organization "+offer.ProviderMSPID)
+	}
+	return nil
+}
+
+// getOffers returns the offers in the catalog, optionally only those of a ThingVisor
+func getOffers(ctx contractapi.TransactionContextInterface, thingVisorID string) ([]Offer, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionCatalog, offerObject, []string{})
+	if err != nil {
+		return nil, internalError(NODE_OFFER, "", err)
+	}
+	results := []Offer{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_OFFER, "", err)
+		}
+		var offer Offer
+		if err := json.Unmarshal(queryResponse.Value, &offer); err != nil {
+			return nil, internalError(NODE_OFFER, queryResponse.Key, err)
+		}
+		if thingVisorID == "" || offer.ThingVisorID == thingVisorID {
+			results = append(results, offer)
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_OFFER, "", err)
+	}
+	return results, nil
+}
+
+// findSubscription decides whether the catalog authorizes binding a vThing to a silo.
+// It returns the approved subscription request of the silo for an open offer covering
+// the vThing, or nil. offered tells whether the vThing is in an open offer at all.
+// When it is in none and the latest offer covering it is closed, the binding is refused.
+func findSubscription(ctx contractapi.TransactionContextInterface, VSiloID string, thingVisorID string, VThingID string) (*SubscriptionRequest, bool, error) {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	offers, err := getOffers(ctx, thingVisorID)
+	if err != nil {
+		return nil, false, err
+	}
+	offered := false
+	var latest *Offer
+	for i := range offers {
+		offer := &offers[i]
+		if !offer.covers(thingVisorID, VThingID) {
+			continue
+		}
+		if latest == nil || offer.newerThan(latest) {
+			latest = offer
+		}
+		if !offer.isOpen(now) {
+			continue
+		}
+		offered = true
+		key, err := ctx.GetStub().CreateCompositeKey(siloSubscriptionObject, []string{VSiloID, offer.OfferID})
+		if err != nil {
+			return nil, false, internalError(NODE_SUBSCRIPTION, VSiloID, err)
+		}
+		requestID, err := ctx.GetStub().GetPrivateData(CollectionCatalog, key)
+		if err != nil {
+			return nil, false, internalError(NODE_SUBSCRIPTION, VSiloID, err)
+		}
+		if requestID == nil {
+			continue
+		}
+		request, err := getSubscriptionState(ctx, string(requestID))
+		if err != nil {
+			return nil, false, err
+		}
+		if request.Status == STATUS_APPROVED {
+			return request, true, nil
+		}
+	}
+	if !offered && latest != nil && latest.isClosed(now) {
+		return nil, false, forbidden(vThingVSiloObject, VSiloID+"/"+VThingID, "vThing "+VThingID+" was offered by "+latest.OfferID+", which is withdrawn or expired")
+	}
+	return nil, offered, nil
+}
+
+func offerGraph(offer *Offer, userID string, userMSPID string) []LogGraph {
+	graph := []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "offer-" + offer.OfferID, SourceType: NODE_USER, TargetType: NODE_OFFER},
+		{Source: "thingvisor-" + offer.ThingVisorID, Target: "offer-" + offer.OfferID, SourceType: NODE_THINGVISOR, TargetType: NODE_OFFER},
+	}
+	if offer.VThingID != "" {
+		graph = append(graph, LogGraph{Source: "vthing-" + offer.VThingID, Target: "offer-" + offer.OfferID, SourceType: NODE_VTHING, TargetType: NODE_OFFER})
+	}
+	return graph
+}
+
+// PublishOffer adds an offer for a vThing or a whole ThingVisor of the organization of
+// the caller to the catalog, described by a JSON document such as
+// {"thingVisorID":"weather","vThingID":"weather/Tokyo","price":"10.00","currency":"EUR",
+// "licence":"CC-BY-4.0","allowedMSPIDs":["Org2MSP"],"validUntil":"2027-01-01T00:00:00Z"}.
+// While the offer is open, its vThings can only be bound by silos with an approved
+// subscription request.
+func (s *SmartContract) PublishOffer(ctx contractapi.TransactionContextInterface, offerID string, offerData string) error {
+	if offerID == "" {
+		return invalidArgument(NODE_OFFER, offerID, "offerID", "offerID must not be empty")
+	}
+	key, err := catalogKey(ctx, offerObject, NODE_OFFER, offerID)
+	if err != nil {
+		return err
+	}
+	exists, err := ctx.GetStub().GetPrivateData(CollectionCatalog, key)
+	if err != nil {
+		return internalError(NODE_OFFER, offerID, err)
+	}
+	if exists != nil {
+		return alreadyExists(NODE_OFFER, offerID)
+	}
+	var offer Offer
+	if err := decodeStrict(NODE_OFFER, offerID, offerData, &offer); err != nil {
+		return err
+	}
+	if offer.OfferID != "" && offer.OfferID != offerID {
+		return invalidArgument(NODE_OFFER, offerID, "offerID", "offerID does not match the offer document")
+	}
+	thingVisor, err := getThingVisorState(ctx, offer.ThingVisorID)
+	if err != nil {
+		return err
+	}
+	if offer.VThingID != "" {
+		thingVisorID, _, err := parseVThingID(offer.VThingID)
+		if err != nil {
+			return err
+		}
+		if thingVisorID != offer.ThingVisorID {
+			return invalidArgument(NODE_OFFER, offerID, "vThingID", "vThing "+offer.VThingID+" is not a vThing of ThingVisor "+offer.ThingVisorID)
+		}
+		if _, err := getVThingState(ctx, offer.VThingID); err != nil {
+			return err
+		}
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if thingVisor.OwnerMSPID != "" && thingVisor.OwnerMSPID != userMSPID {
+		return forbidden(NODE_OFFER, offerID, "ThingVisor "+offer.ThingVisorID+" is owned by organization "+thingVisor.OwnerMSPID)
+	}
+	if offer.AllowedMSPIDs == nil {
+		offer.AllowedMSPIDs = []string{}
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	var validFrom time.Time
+	if offer.ValidFrom != "" {
+		if validFrom, err = time.Parse(time.RFC3339, offer.ValidFrom); err != nil {
+			return invalidArgument(NODE_OFFER, offerID, "validFrom", "validFrom must be an RFC 3339 timestamp")
+		}
+	}
+	if offer.ValidUntil != "" {
+		validUntil, err := time.Parse(time.RFC3339, offer.ValidUntil)
+		if err != nil {
+			return invalidArgument(NODE_OFFER, offerID, "validUntil", "validUntil must be an RFC 3339 timestamp")
+		}
+		if !now.Before(validUntil) || !validFrom.Before(validUntil) {
+			return invalidArgument(NODE_OFFER, offerID, "validUntil", "validUntil must be in the future and after validFrom")
+		}
+	}
+	offer.OfferID = offerID
+	offer.ProviderMSPID = userMSPID
+	offer.Status = STATUS_ACTIVE
+	offer.CreatedBy = userID
+	offer.CreationTime = now.Format(time.RFC3339)
+	offer.Version = 0
+	if err := putOfferState(ctx, &offer); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "PublishOffer", offerGraph(&offer, userID, userMSPID), userID, userMSPID)
+}
+
+// WithdrawOffer closes an offer. Bindings made under it are kept; new ones are refused.
+func (s *SmartContract) WithdrawOffer(ctx contractapi.TransactionContextInterface, offerID string) error {
+	offer, err := getOfferState(ctx, offerID)
+	if err != nil {
+		return err
+	}
+	if err := checkOfferProvider(ctx, offer); err != nil {
+		return err
+	}
+	if offer.Status == STATUS_WITHDRAWN {
+		return invalidState(NODE_OFFER, offerID, "offer "+offerID+" is already withdrawn")
+	}
+	offer.Status = STATUS_WITHDRAWN
+	if err := putOfferState(ctx, offer); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return SetHistory(ctx, "WithdrawOffer", offerGraph(offer, userID, userMSPID), userID, userMSPID)
+}
+
+// GetOffer returns an offer to its provider and to the organizations it admits
+func (s *SmartContract) GetOffer(ctx contractapi.TransactionContextInterface, offerID string) (*Offer, error) {
+	offer, err := getOfferState(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if !offer.visibleTo(userMSPID) {
+		return nil, forbidden(NODE_OFFER, offerID, "offer "+offerID+" is not open to organization "+userMSPID)
+	}
+	return offer, nil
+}
+
+// GetOffers returns the offers of the catalog the organization of the caller can see,
+// or only those of a ThingVisor when thingVisorID is not empty. Offers restricted by
+// allowedMSPIDs are left out for other organizations.
+func (s *SmartContract) GetOffers(ctx contractapi.TransactionContextInterface, thingVisorID string) ([]Offer, error) {
+	offers, err := getOffers(ctx, thingVisorID)
+	if err != nil {
+		return nil, err
+	}
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	results := []Offer{}
+	for _, offer := range offers {
+		if offer.visibleTo(userMSPID) {
+			results = append(results, offer)
+		}
+	}
+	return results, nil
+}
+
+// SubmitSubscriptionRequest asks the provider of an open offer to let a VirtualSilo of
+// the caller bind its vThings
+func (s *SmartContract) SubmitSubscriptionRequest(ctx contractapi.TransactionContextInterface, requestID string, offerID string, VSiloID string) error {
+	if requestID == "" {
+		return invalidArgument(NODE_SUBSCRIPTION, requestID, "requestID", "requestID must not be empty")
+	}
+	key, err := catalogKey(ctx, subscriptionObject, NODE_SUBSCRIPTION, requestID)
+	if err != nil {
+		return err
+	}
+	exists, err := ctx.GetStub().GetPrivateData(CollectionCatalog, key)
+	if err != nil {
+		return internalError(NODE_SUBSCRIPTION, requestID, err)
+	}
+	if exists != nil {
+		return alreadyExists(NODE_SUBSCRIPTION, requestID)
+	}
+	offer, err := getOfferState(ctx, offerID)
+	if err != nil {
+		return err
+	}
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !offer.isOpen(now) {
+		return invalidState(NODE_OFFER, offerID, "offer "+offerID+" is not open")
+	}
+	if !offer.admits(silo.OwnerMSPID) {
+		return forbidden(NODE_OFFER, offerID, "offer "+offerID+" is not open to organization "+silo.OwnerMSPID)
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	request := SubscriptionRequest{
+		RequestID:     requestID,
+		OfferID:       offerID,
+		VSiloID:       VSiloID,
+		TenantID:      silo.TenantID,
+		ProviderMSPID: offer.ProviderMSPID,
+		ConsumerMSPID: silo.OwnerMSPID,
+		RequestedBy:   userID,
+		Status:        STATUS_PENDING,
+		CreationTime:  now.Format(time.RFC3339),
+	}
+	if err := putSubscriptionState(ctx, &request); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "SubmitSubscriptionRequest", []LogGraph{
+		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+		{Source: "tenant-" + userID, Target: "subscription-" + requestID, SourceType: NODE_USER, TargetType: NODE_SUBSCRIPTION},
+		{Source: "silo-" + VSiloID, Target: "subscription-" + requestID, SourceType: NODE_VSILO, TargetType: NODE_SUBSCRIPTION},
+		{Source: "subscription-" + requestID, Target: "offer-" + offerID, SourceType: NODE_SUBSCRIPTION, TargetType: NODE_OFFER},
+	}, userID, userMSPID)
+}
+
+// decideSubscriptionRequest approves or rejects a pending request on behalf of the
+// provider of its offer
+func decideSubscriptionRequest(ctx contractapi.TransactionContextInterface, eventName string, requestID string, status string, reason string) error {
+	request, err := getSubscriptionState(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	offer, err := getOfferState(ctx, request.OfferID)
+	if err != nil {
+		return err
+	}
+	if err := checkOfferProvider(ctx, offer); err != nil {
+		return err
+	}
+	if request.Status != STATUS_PENDING {
+		return invalidState(NODE_SUBSCRIPTION, requestID, "subscription request "+requestID+" is "+request.Status+", not "+STATUS_PENDING)
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if status == STATUS_APPROVED {
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		if !offer.isOpen(now) {
+			return invalidState(NODE_OFFER, offer.OfferID, "offer "+offer.OfferID+" is not open")
+		}
+		key, err := ctx.GetStub().CreateCompositeKey(siloSubscriptionObject, []string{request.VSiloID, request.OfferID})
+		if err != nil {
+			return internalError(NODE_SUBSCRIPTION, requestID, err)
+		}
+		if err := ctx.GetStub().PutPrivateData(CollectionCatalog, key, []byte(requestID)); err != nil {
+			return internalError(NODE_SUBSCRIPTION, requestID, err)
+		}
+	}
+	request.Status = status
+	request.DecidedBy = userID
+	request.Reason = reason
+	if err := putSubscriptionState(ctx, request); err != nil {
+		return err
+	}
+	return SetHistory(ctx, eventName, []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "subscription-" + requestID, SourceType: NODE_USER, TargetType: NODE_SUBSCRIPTION},
+		{Source: "subscription-" + requestID, Target: "offer-" + request.OfferID, SourceType: NODE_SUBSCRIPTION, TargetType: NODE_OFFER},
+	}, userID, userMSPID)
+}
+
+// ApproveSubscriptionRequest lets the silo of a pending request bind the vThings of the offer
+func (s *SmartContract) ApproveSubscriptionRequest(ctx contractapi.TransactionContextInterface, requestID string) error {
+	return decideSubscriptionRequest(ctx, "ApproveSubscriptionRequest", requestID, STATUS_APPROVED, "")
+}
+
+// RejectSubscriptionRequest turns a pending request down, giving a reason to the consumer
+func (s *SmartContract) RejectSubscriptionRequest(ctx contractapi.TransactionContextInterface, requestID string, reason string) error {
+	return decideSubscriptionRequest(ctx, "RejectSubscriptionRequest", requestID, STATUS_REJECTED, reason)
+}
+
+// GetSubscriptionRequest returns a request to the organizations of its provider and consumer
+func (s *SmartContract) GetSubscriptionRequest(ctx contractapi.TransactionContextInterface, requestID string) (*SubscriptionRequest, error) {
+	request, err := getSubscriptionState(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if userMSPID != request.ProviderMSPID && userMSPID != request.ConsumerMSPID {
+		return nil, forbidden(NODE_SUBSCRIPTION, requestID, "organization "+userMSPID+" is not a party to subscription request "+requestID)
+	}
+	return request, nil
+}
+
+// GetSubscriptionRequests returns the requests made to or by the organization of the
+// caller, optionally only those of an offer
+func (s *SmartContract) GetSubscriptionRequests(ctx contractapi.TransactionContextInterface, offerID string) ([]SubscriptionRequest, error) {
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionCatalog, subscriptionObject, []string{})
+	if err != nil {
+		return nil, internalError(NODE_SUBSCRIPTION, "", err)
+	}
+	results := []SubscriptionRequest{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_SUBSCRIPTION, "", err)
+		}
+		var request SubscriptionRequest
+		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
+			return nil, internalError(NODE_SUBSCRIPTION, queryResponse.Key, err)
+		}
+		if userMSPID != request.ProviderMSPID && userMSPID != request.ConsumerMSPID {
+			continue
+		}
+		if offerID == "" || request.OfferID == offerID {
+			results = append(results, request)
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_SUBSCRIPTION, "", err)
+	}
+	return results, nil
+}
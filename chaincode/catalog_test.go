@@ -0,0 +1,104 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// putTestOffers stores offers of Org1MSP for the ThingVisor "parking"
+func putTestOffers(t *testing.T, ctx contractapi.TransactionContextInterface, offers ...Offer) {
+	for i := range offers {
+		offer := offers[i]
+		offer.ThingVisorID = "parking"
+		offer.ProviderMSPID = "Org1MSP"
+		if err := putOfferState(ctx, &offer); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOfferNewerThan(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer Offer
+		other Offer
+		newer bool
+	}{
+		{name: "later", offer: Offer{OfferID: "a", CreationTime: "2021-03-01T00:00:01Z"}, other: Offer{OfferID: "b", CreationTime: "2021-03-01T00:00:00Z"}, newer: true},
+		{name: "earlier", offer: Offer{OfferID: "b", CreationTime: "2021-03-01T00:00:00Z"}, other: Offer{OfferID: "a", CreationTime: "2021-03-01T00:00:01Z"}},
+		{name: "later in another zone", offer: Offer{OfferID: "a", CreationTime: "2021-03-01T09:00:01+09:00"}, other: Offer{OfferID: "b", CreationTime: "2021-03-01T00:00:00Z"}, newer: true},
+		{name: "same second, greater offerID", offer: Offer{OfferID: "b", CreationTime: "2021-03-01T00:00:00Z"}, other: Offer{OfferID: "a", CreationTime: "2021-03-01T09:00:00+09:00"}, newer: true},
+		{name: "same second, smaller offerID", offer: Offer{OfferID: "a", CreationTime: "2021-03-01T00:00:00Z"}, other: Offer{OfferID: "b", CreationTime: "2021-03-01T00:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if newer := tt.offer.newerThan(&tt.other); newer != tt.newer {
+				t.Errorf("newerThan = %v, want %v", newer, tt.newer)
+			}
+		})
+	}
+}
+
+func TestFindSubscriptionLatestOfferOfTheSameSecond(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "tenant2", "Org2MSP", ROLE_CONSUMER)
+	created := rfc3339(testTime)
+	putTestOffers(t, ctx,
+		Offer{OfferID: "summer", VThingID: "parking/lot1", Status: STATUS_WITHDRAWN, CreationTime: created},
+		Offer{OfferID: "spring", Status: STATUS_WITHDRAWN, CreationTime: created},
+		Offer{OfferID: "autumn", Status: STATUS_ACTIVE, CreationTime: created, VThingID: "parking/lot2"},
+	)
+	stub.MockTransactionEnd("tx0")
+	err := stub.invoke("tx1", testTime+60, func() error {
+		_, _, err := findSubscription(ctx, "tenant2_car", "parking", "parking/lot1")
+		return err
+	})
+	if errorCode(err) != CODE_FORBIDDEN || !strings.Contains(err.Error(), "summer") {
+		t.Errorf("error = %v, want FORBIDDEN naming offer summer", err)
+	}
+}
+
+func TestGetOffersVisibility(t *testing.T) {
+	stub := newTestStub()
+	provider := newTestContext(stub, "provider1", "Org1MSP", ROLE_PROVIDER)
+	putTestOffers(t, provider,
+		Offer{OfferID: "public", Status: STATUS_ACTIVE},
+		Offer{OfferID: "partners", Status: STATUS_ACTIVE, AllowedMSPIDs: []string{"Org2MSP"}},
+	)
+	stub.MockTransactionEnd("tx0")
+	tests := []struct {
+		mspID  string
+		offers []string
+	}{
+		{mspID: "Org1MSP", offers: []string{"partners", "public"}},
+		{mspID: "Org2MSP", offers: []string{"partners", "public"}},
+		{mspID: "Org3MSP", offers: []string{"public"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mspID, func(t *testing.T) {
+			ctx := newTestContext(stub, "user1", tt.mspID, ROLE_CONSUMER)
+			offers, err := (&SmartContract{}).GetOffers(ctx, "parking")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var IDs []string
+			for _, offer := range offers {
+				IDs = append(IDs, offer.OfferID)
+			}
+			if !reflect.DeepEqual(IDs, tt.offers) {
+				t.Errorf("offers = %v, want %v", IDs, tt.offers)
+			}
+			_, err = (&SmartContract{}).GetOffer(ctx, "partners")
+			if visible := err == nil; visible != (len(tt.offers) == 2) {
+				t.Errorf("GetOffer(partners) = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	CODE_NOT_FOUND        string = "NOT_FOUND"
+	CODE_ALREADY_EXISTS   string = "ALREADY_EXISTS"
+	CODE_INVALID_STATE    string = "INVALID_STATE"
+	CODE_FORBIDDEN        string = "FORBIDDEN"
+	CODE_INVALID_ARGUMENT string = "INVALID_ARGUMENT"
+	CODE_CONFLICT         string = "CONFLICT"
+	CODE_INTERNAL         string = "INTERNAL"
+)
+
+// ChaincodeError is serialized as JSON into the chaincode error message so that
+// clients can branch on Code instead of matching the message text
+type ChaincodeError struct {
+	Code    string `json:"code"`
+	Kind    string `json:"kind,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ChaincodeError) Error() string {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(data)
+}
+
+func notFound(kind string, id string) error {
+	return &ChaincodeError{Code: CODE_NOT_FOUND, Kind: kind, ID: id, Message: kind + " " + id + " does not exist"}
+}
+
+func alreadyExists(kind string, id string) error {
+	return &ChaincodeError{Code: CODE_ALREADY_EXISTS, Kind: kind, ID: id, Message: kind + " " + id + " already exists"}
+}
+
+func invalidState(kind string, id string, message string) error {
+	return &ChaincodeError{Code: CODE_INVALID_STATE, Kind: kind, ID: id, Message: message}
+}
+
+func forbidden(kind string, id string, message string) error {
+	return &ChaincodeError{Code: CODE_FORBIDDEN, Kind: kind, ID: id, Message: message}
+}
+
+func invalidArgument(kind string, id string, field string, message string) error {
+	return &ChaincodeError{Code: CODE_INVALID_ARGUMENT, Kind: kind, ID: id, Field: field, Message: message}
+}
+
+// conflict reports that an asset was changed since the caller read it
+func conflict(kind string, id string, expected int, current int) error {
+	return &ChaincodeError{Code: CODE_CONFLICT, Kind: kind, ID: id, Field: "version", Message: kind + " " + id + " is at version " + strconv.Itoa(current) + ", expected " + strconv.Itoa(expected)}
+}
+
+// internalError wraps failures of the stub or of the JSON codec; errors that
+// are already a ChaincodeError are returned unchanged
+func internalError(kind string, id string, err error) error {
+	if _, ok := err.(*ChaincodeError); ok {
+		return err
+	}
+	return &ChaincodeError{Code: CODE_INTERNAL, Kind: kind, ID: id, Message: err.Error()}
+}
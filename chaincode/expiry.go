@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ExpiredBindingSweep lists the bindings removed by SweepExpiredBindings. More tells
+// that expired bindings are left for another sweep.
+type ExpiredBindingSweep struct {
+	Bindings []VThingVSilo `json:"bindings"`
+	More     bool          `json:"more"`
+}
+
+// parseBindingValidity checks the optional RFC 3339 bounds of a new binding
+func parseBindingValidity(ctx contractapi.TransactionContextInterface, id string, validFrom string, validUntil string) error {
+	var from time.Time
+	var err error
+	if validFrom != "" {
+		if from, err = time.Parse(time.RFC3339, validFrom); err != nil {
+			return invalidArgument(vThingVSiloObject, id, "validFrom", "validFrom must be an RFC 3339 timestamp")
+		}
+	}
+	if validUntil == "" {
+		return nil
+	}
+	until, err := time.Parse(time.RFC3339, validUntil)
+	if err != nil {
+		return invalidArgument(vThingVSiloObject, id, "validUntil", "validUntil must be an RFC 3339 timestamp")
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(until) || !from.Before(until) {
+		return invalidArgument(vThingVSiloObject, id, "validUntil", "validUntil must be in the future and after validFrom")
+	}
+	return nil
+}
+
+// expired reports whether the validity of the binding ended before the time now
+func (b *VThingVSilo) expired(now time.Time) bool {
+	if b.ValidUntil == "" {
+		return false
+	}
+	validUntil, err := time.Parse(time.RFC3339, b.ValidUntil)
+	return err == nil && !now.Before(validUntil)
+}
+
+// active reports whether the time now lies within [ValidFrom, ValidUntil); a binding
+// whose validity has not started yet is kept but not consumed
+func (b *VThingVSilo) active(now time.Time) bool {
+	if b.expired(now) {
+		return false
+	}
+	if b.ValidFrom == "" {
+		return true
+	}
+	validFrom, err := time.Parse(time.RFC3339, b.ValidFrom)
+	return err == nil && !now.Before(validFrom)
+}
+
+// SweepExpiredBindings removes up to batchSize expired bindings of the organization of
+// the caller; More reports that expired bindings remain for another call. Since a
+// transaction keeps a single event, the deletions are emitted as one
+// "SweepExpiredBindings" event whose History payload has, after the caller edges, one
+// {source: "silo-<vSiloID>", target: "vthing-<vThingID>", source_type: "deleted"}
+// edge per removed binding, from which the master-controller tears down the
+// subscriptions of the silos. Nothing is emitted when no binding has expired.
+func (s *SmartContract) SweepExpiredBindings(ctx contractapi.TransactionContextInterface, batchSize int) (*ExpiredBindingSweep, error) {
+	if batchSize <= 0 || batchSize > int(MAX_PAGE_SIZE) {
+		return nil, invalidArgument(vThingVSiloObject, "", "batchSize", "batchSize must be between 1 and "+strconv.Itoa(int(MAX_PAGE_SIZE)))
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vThingVSiloObject, []string{vThingVSiloPrefix})
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, "", err)
+	}
+	sweep := ExpiredBindingSweep{Bindings: []VThingVSilo{}}
+	var keys []string
+	for resultsIterator.HasNext() && !sweep.More {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(vThingVSiloObject, "", err)
+		}
+		var vThingVSilo VThingVSilo
+		if err := json.Unmarshal(queryResponse.Value, &vThingVSilo); err != nil {
+			return nil, internalError(vThingVSiloObject, queryResponse.Key, err)
+		}
+		if !vThingVSilo.expired(now) {
+			continue
+		}
+		if len(keys) == batchSize {
+			sweep.More = true
+			continue
+		}
+		keys = append(keys, queryResponse.Key)
+		sweep.Bindings = append(sweep.Bindings, vThingVSilo)
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, "", err)
+	}
+	if len(keys) == 0 {
+		return &sweep, nil
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := []LogGraph{
+		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+	}
+	var silos []string
+	for i, key := range keys {
+		binding := sweep.Bindings[i]
+		if err := deleteBinding(ctx, key, binding.VSiloID, binding.VThingID, binding.OwnerMSPID); err != nil {
+			return nil, err
+		}
+		silos = append(silos, binding.VSiloID)
+		graph = append(graph, LogGraph{Source: "silo-" + binding.VSiloID, Target: "vthing-" + binding.VThingID, SourceType: NODE_DELETED, TargetType: NODE_VTHING})
+	}
+	if err := releaseBindings(ctx, silos); err != nil {
+		return nil, err
+	}
+	if err := SetHistory(ctx, "SweepExpiredBindings", graph, userID, userMSPID); err != nil {
+		return nil, err
+	}
+	return &sweep, nil
+}
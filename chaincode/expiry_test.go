@@ -0,0 +1,191 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rfc3339 formats a Unix time as the chaincode stores it
+func rfc3339(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+func TestBindingValidity(t *testing.T) {
+	tests := []struct {
+		name       string
+		validFrom  string
+		validUntil string
+		active     bool
+		expired    bool
+	}{
+		{name: "unbounded", active: true},
+		{name: "started", validFrom: rfc3339(testTime - 60), active: true},
+		{name: "starts now", validFrom: rfc3339(testTime), active: true},
+		{name: "not started", validFrom: rfc3339(testTime + 60)},
+		{name: "not started, then ends", validFrom: rfc3339(testTime + 60), validUntil: rfc3339(testTime + 120)},
+		{name: "ends later", validUntil: rfc3339(testTime + 60), active: true},
+		{name: "ends now", validUntil: rfc3339(testTime), expired: true},
+		{name: "ended", validFrom: rfc3339(testTime - 120), validUntil: rfc3339(testTime - 60), expired: true},
+	}
+	now := time.Unix(testTime, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binding := VThingVSilo{ValidFrom: tt.validFrom, ValidUntil: tt.validUntil}
+			if active := binding.active(now); active != tt.active {
+				t.Errorf("active = %v, want %v", active, tt.active)
+			}
+			if expired := binding.expired(now); expired != tt.expired {
+				t.Errorf("expired = %v, want %v", expired, tt.expired)
+			}
+		})
+	}
+}
+
+func TestGettersReturnActiveBindings(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+	putTestVThings(t, ctx, "weather", "Org1MSP", "Tokyo", "Osaka")
+	putTestSilo(t, ctx, "tenant1_a", "Org1MSP")
+	stub.MockTransactionEnd("tx0")
+	contract := &SmartContract{}
+	validFrom, validUntil := rfc3339(testTime+3600), rfc3339(testTime+7200)
+	if err := stub.invoke("tx1", testTime, func() error {
+		return contract.AddVThingVSilo(ctx, "tenant1_a", "weather/Tokyo", validFrom, validUntil, "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.invoke("tx2", testTime, func() error {
+		return contract.AddVThingVSilo(ctx, "tenant1_a", "weather/Osaka", "", "", "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		time    int64
+		vThings []string
+	}{
+		{name: "before validFrom", time: testTime + 60, vThings: []string{"weather/Osaka"}},
+		{name: "at validFrom", time: testTime + 3600, vThings: []string{"weather/Osaka", "weather/Tokyo"}},
+		{name: "at validUntil", time: testTime + 7200, vThings: []string{"weather/Osaka"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bySilo, byTenant, tokyo []VThingVSilo
+			err := stub.invoke("tx3", tt.time, func() error {
+				var err error
+				if bySilo, err = contract.GetVThingVSilosByVSiloID(ctx, "tenant1_a"); err != nil {
+					return err
+				}
+				if byTenant, err = contract.GetVThingVSilosByTenantID(ctx, "tenant1"); err != nil {
+					return err
+				}
+				tokyo, err = contract.GetVThingVSilo(ctx, "tenant1_a", "weather/Tokyo")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, bindings := range [][]VThingVSilo{bySilo, byTenant} {
+				var vThings []string
+				for _, binding := range bindings {
+					vThings = append(vThings, binding.VThingID)
+				}
+				if !reflect.DeepEqual(vThings, tt.vThings) {
+					t.Errorf("vThings = %v, want %v", vThings, tt.vThings)
+				}
+			}
+			if (len(tokyo) == 1) != (len(tt.vThings) == 2) {
+				t.Errorf("binding of weather/Tokyo = %+v", tokyo)
+			}
+		})
+	}
+}
+
+func TestSweepExpiredBindings(t *testing.T) {
+	stub := newTestStub()
+	consumer := newTestContext(stub, "tenant2", "Org2MSP", ROLE_CONSUMER)
+	putTestVThings(t, consumer, "traffic", "Org2MSP", "Shibuya", "Shinjuku", "Ginza")
+	putTestSilo(t, consumer, "tenant2_x", "Org2MSP")
+	putTestSilo(t, consumer, "tenant2_y", "Org2MSP")
+	stub.MockTransactionEnd("tx0")
+	contract := &SmartContract{}
+	bindings := []struct {
+		VSiloID    string
+		VThingID   string
+		validFrom  string
+		validUntil string
+	}{
+		{VSiloID: "tenant2_x", VThingID: "traffic/Shibuya", validUntil: rfc3339(testTime + 60)},
+		{VSiloID: "tenant2_y", VThingID: "traffic/Shibuya", validUntil: rfc3339(testTime + 120)},
+		{VSiloID: "tenant2_x", VThingID: "traffic/Shinjuku"},
+		{VSiloID: "tenant2_y", VThingID: "traffic/Ginza", validFrom: rfc3339(testTime + 3600), validUntil: rfc3339(testTime + 7200)},
+	}
+	for _, binding := range bindings {
+		if err := stub.invoke("tx1", testTime, func() error {
+			return contract.AddVThingVSilo(consumer, binding.VSiloID, binding.VThingID, binding.validFrom, binding.validUntil, "")
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := newTestContext(stub, "admin2", "Org2MSP", ROLE_ADMIN)
+	sweep := func(txID string, batchSize int) (*ExpiredBindingSweep, error) {
+		var result *ExpiredBindingSweep
+		err := stub.invoke(txID, testTime+600, func() error {
+			var err error
+			result, err = contract.SweepExpiredBindings(admin, batchSize)
+			return err
+		})
+		return result, err
+	}
+	for _, batchSize := range []int{0, int(MAX_PAGE_SIZE) + 1} {
+		if _, err := sweep("tx2", batchSize); errorCode(err) != CODE_INVALID_ARGUMENT {
+			t.Errorf("batchSize %d: error = %v, want %s", batchSize, err, CODE_INVALID_ARGUMENT)
+		}
+	}
+
+	var swept []string
+	for i, more := range []bool{true, false} {
+		result, err := sweep("tx3", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Bindings) != 1 || result.More != more {
+			t.Fatalf("sweep %d = %+v, want one binding and More %v", i, result, more)
+		}
+		if !reflect.DeepEqual(stub.events, []string{"SweepExpiredBindings"}) {
+			t.Errorf("events = %v", stub.events)
+		}
+		var history History
+		if err := json.Unmarshal(stub.payload, &history); err != nil {
+			t.Fatal(err)
+		}
+		binding := result.Bindings[0]
+		deletion := LogGraph{Source: "silo-" + binding.VSiloID, Target: "vthing-" + binding.VThingID, SourceType: NODE_DELETED, TargetType: NODE_VTHING}
+		if n := len(history.LogGraphs); n != 3 || history.LogGraphs[0].Target != "tenant-admin2" || history.LogGraphs[n-1] != deletion {
+			t.Errorf("graph = %+v, want the caller edges and %+v", history.LogGraphs, deletion)
+		}
+		swept = append(swept, binding.VSiloID+" "+binding.VThingID)
+	}
+	if want := []string{"tenant2_x traffic/Shibuya", "tenant2_y traffic/Shibuya"}; !reflect.DeepEqual(swept, want) {
+		t.Errorf("swept = %v, want %v", swept, want)
+	}
+
+	result, err := sweep("tx4", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Bindings) != 0 || result.More || len(stub.events) != 0 {
+		t.Errorf("sweep = %+v with events %v, want nothing left to sweep", result, stub.events)
+	}
+	for _, VSiloID := range []string{"tenant2_x", "tenant2_y"} {
+		if got := siloVThings(admin, VSiloID); got != 1 {
+			t.Errorf("vThings of %s = %d, want 1", VSiloID, got)
+		}
+	}
+}
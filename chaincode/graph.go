@@ -0,0 +1,237 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	graphNodeObject      string = "graphNode"
+	graphEdgeObject      string = "graphEdge"
+	graphInEdgeObject    string = "graphInEdge"
+	MAX_PROVENANCE_DEPTH int    = 10
+)
+
+// GraphNode is the latest known state of a node of the provenance graph. Owner is
+// kept for silo nodes, from the user who first drew them, to filter the graph.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	TxID      string `json:"tx_id"`
+	EventName string `json:"event_name"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// GraphEdge is an edge of the provenance graph together with the last transaction that drew it
+type GraphEdge struct {
+	Source     string `json:"source"`
+	SourceType string `json:"source_type"`
+	Target     string `json:"target"`
+	TargetType string `json:"target_type"`
+	TxID       string `json:"tx_id"`
+	EventName  string `json:"event_name"`
+	Time       string `json:"time"`
+}
+
+type ProvenanceGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// recordGraph stores the edges of a History record as adjacency keys, one keyed by
+// source and one keyed by target, so that the graph can be walked in both directions
+func recordGraph(ctx contractapi.TransactionContextInterface, history *History) error {
+	for _, edge := range history.LogGraphs {
+		graphEdge := GraphEdge{
+			Source:     edge.Source,
+			SourceType: edge.SourceType,
+			Target:     edge.Target,
+			TargetType: edge.TargetType,
+			TxID:       history.TxID,
+			EventName:  history.EventName,
+			Time:       history.Time,
+		}
+		edgeJSON, err := json.Marshal(graphEdge)
+		if err != nil {
+			return internalError("", "", err)
+		}
+		outKey, err := ctx.GetStub().CreateCompositeKey(graphEdgeObject, []string{edge.Source, edge.Target})
+		if err != nil {
+			return internalError("", edge.Source, err)
+		}
+		inKey, err := ctx.GetStub().CreateCompositeKey(graphInEdgeObject, []string{edge.Target, edge.Source})
+		if err != nil {
+			return internalError("", edge.Target, err)
+		}
+		for _, key := range []string{outKey, inKey} {
+			if err := ctx.GetStub().PutPrivateData(CollectionHistory, key, edgeJSON); err != nil {
+				return internalError("", "", err)
+			}
+		}
+		for _, node := range []GraphNode{
+			{ID: edge.Source, Type: edge.SourceType, TxID: history.TxID, EventName: history.EventName},
+			{ID: edge.Target, Type: edge.TargetType, TxID: history.TxID, EventName: history.EventName},
+		} {
+			if strings.HasPrefix(node.ID, "silo-") {
+				current, err := getGraphNode(ctx, node.ID)
+				if err != nil {
+					return err
+				}
+				node.Owner = history.UserID
+				if current != nil && current.Owner != "" {
+					node.Owner = current.Owner
+				}
+			}
+			nodeKey, err := ctx.GetStub().CreateCompositeKey(graphNodeObject, []string{node.ID})
+			if err != nil {
+				return internalError("", node.ID, err)
+			}
+			nodeJSON, err := json.Marshal(node)
+			if err != nil {
+				return internalError("", node.ID, err)
+			}
+			if err := ctx.GetStub().PutPrivateData(CollectionHistory, nodeKey, nodeJSON); err != nil {
+				return internalError("", node.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func getGraphNode(ctx contractapi.TransactionContextInterface, id string) (*GraphNode, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(graphNodeObject, []string{id})
+	if err != nil {
+		return nil, internalError("", id, err)
+	}
+	data, err := ctx.GetStub().GetPrivateData(CollectionHistory, key)
+	if err != nil {
+		return nil, internalError("", id, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var node GraphNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, internalError("", id, err)
+	}
+	return &node, nil
+}
+
+// getGraphEdges returns the edges leaving (graphEdgeObject) or entering
+// (graphInEdgeObject) a node
+func getGraphEdges(ctx contractapi.TransactionContextInterface, objectType string, id string) ([]GraphEdge, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionHistory, objectType, []string{id})
+	if err != nil {
+		return nil, internalError("", id, err)
+	}
+	var results []GraphEdge
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError("", id, err)
+		}
+		var edge GraphEdge
+		if err := json.Unmarshal(queryResponse.Value, &edge); err != nil {
+			return nil, internalError("", id, err)
+		}
+		results = append(results, edge)
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError("", id, err)
+	}
+	return results, nil
+}
+
+// canSeeGraphNode hides the silo nodes, and so the bindings, of other tenants
+func canSeeGraphNode(ctx contractapi.TransactionContextInterface, node *GraphNode) bool {
+	return !strings.HasPrefix(node.ID, "silo-") || canAccessSilo(ctx, node.Owner)
+}
+
+// GetProvenanceGraph returns the nodes and edges reachable from a node within depth
+// hops, following edges in both directions. Nodes are named as in the history graph,
+// e.g. thingvisor-<id>, vthing-<id>, silo-<id>, flavour-<id> or tenant-<userID>.
+// Organization nodes are returned but not walked through, as every user of the
+// organization hangs off them. Silos of other owners, and their edges, are left out
+// unless the caller is an admin.
+func (s *SmartContract) GetProvenanceGraph(ctx contractapi.TransactionContextInterface, entityID string, depth int) (*ProvenanceGraph, error) {
+	if depth < 1 || depth > MAX_PROVENANCE_DEPTH {
+		return nil, invalidArgument("", entityID, "depth", "depth must be between 1 and "+strconv.Itoa(MAX_PROVENANCE_DEPTH))
+	}
+	root, err := getGraphNode(ctx, entityID)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, notFound("node", entityID)
+	}
+	if !canSeeGraphNode(ctx, root) {
+		return nil, forbidden(NODE_VSILO, entityID, entityID+" is owned by another identity")
+	}
+	nodes := map[string]GraphNode{root.ID: *root}
+	edges := map[string]GraphEdge{}
+	hidden := map[string]bool{}
+	frontier := []string{root.ID}
+	for level := 0; level < depth && len(frontier) > 0; level++ {
+		var next []string
+		for _, id := range frontier {
+			if nodes[id].Type == NODE_ORG_PROVIDER || nodes[id].Type == NODE_ORG_CONSUMER {
+				continue
+			}
+			outEdges, err := getGraphEdges(ctx, graphEdgeObject, id)
+			if err != nil {
+				return nil, err
+			}
+			inEdges, err := getGraphEdges(ctx, graphInEdgeObject, id)
+			if err != nil {
+				return nil, err
+			}
+			for _, edge := range append(outEdges, inEdges...) {
+				neighbour := edge.Target
+				if neighbour == id {
+					neighbour = edge.Source
+				}
+				if hidden[neighbour] {
+					continue
+				}
+				if _, ok := nodes[neighbour]; !ok {
+					node, err := getGraphNode(ctx, neighbour)
+					if err != nil {
+						return nil, err
+					}
+					if node == nil || !canSeeGraphNode(ctx, node) {
+						hidden[neighbour] = true
+						continue
+					}
+					nodes[neighbour] = *node
+					next = append(next, neighbour)
+				}
+				edges[edge.Source+"\x00"+edge.Target] = edge
+			}
+		}
+		frontier = next
+	}
+	graph := ProvenanceGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	for _, edge := range edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Source != graph.Edges[j].Source {
+			return graph.Edges[i].Source < graph.Edges[j].Source
+		}
+		return graph.Edges[i].Target < graph.Edges[j].Target
+	})
+	return &graph, nil
+}
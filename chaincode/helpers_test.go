@@ -0,0 +1,298 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// testTime is the timestamp of the first transaction of a testStub, 2021-01-01T00:00:00Z
+const testTime int64 = 1609459200
+
+// testStub completes shimtest.MockStub with the private data calls the chaincode
+// uses and records the events of the transactions. Like a peer, it keeps the writes
+// of a transaction from its reads, and commits them when the transaction ends.
+type testStub struct {
+	*shimtest.MockStub
+	events  []string
+	payload []byte
+	// writes holds the pending writes by collection, "" for public state; a nil
+	// value deletes the key
+	writes map[string]map[string][]byte
+	// history holds the committed modifications of the public keys, latest first
+	history map[string][]*queryresult.KeyModification
+}
+
+func newTestStub() *testStub {
+	stub := &testStub{MockStub: shimtest.NewMockStub("viriot", nil), history: map[string][]*queryresult.KeyModification{}}
+	stub.startTx("tx0", testTime)
+	return stub
+}
+
+// startTx starts a new transaction at the given Unix time, discarding the writes of
+// a transaction that was not ended
+func (stub *testStub) startTx(txID string, seconds int64) {
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: seconds}
+	stub.events = nil
+	stub.writes = map[string]map[string][]byte{}
+}
+
+// MockTransactionEnd commits the writes of the transaction
+func (stub *testStub) MockTransactionEnd(uuid string) {
+	for collection, values := range stub.writes {
+		for key, value := range values {
+			if collection == "" {
+				modification := &queryresult.KeyModification{TxId: uuid, Value: value, Timestamp: stub.TxTimestamp, IsDelete: value == nil}
+				stub.history[key] = append([]*queryresult.KeyModification{modification}, stub.history[key]...)
+			}
+			switch {
+			case collection == "" && value == nil:
+				_ = stub.MockStub.DelState(key)
+			case collection == "":
+				_ = stub.MockStub.PutState(key, value)
+			case value == nil:
+				delete(stub.PvtState[collection], key)
+			default:
+				_ = stub.MockStub.PutPrivateData(collection, key, value)
+			}
+		}
+	}
+	stub.writes = map[string]map[string][]byte{}
+	stub.MockStub.MockTransactionEnd(uuid)
+}
+
+// invoke runs call as transaction txID at the given Unix time. Its writes are
+// committed when it succeeds and discarded when it fails, as a peer would.
+func (stub *testStub) invoke(txID string, seconds int64, call func() error) error {
+	stub.startTx(txID, seconds)
+	if err := call(); err != nil {
+		stub.writes = map[string]map[string][]byte{}
+		stub.MockStub.MockTransactionEnd(txID)
+		return err
+	}
+	stub.MockTransactionEnd(txID)
+	return nil
+}
+
+func (stub *testStub) write(collection string, key string, value []byte) error {
+	if stub.TxID == "" {
+		return errors.New("no transaction was started")
+	}
+	if stub.writes[collection] == nil {
+		stub.writes[collection] = map[string][]byte{}
+	}
+	stub.writes[collection][key] = value
+	return nil
+}
+
+func (stub *testStub) PutState(key string, value []byte) error {
+	if len(value) == 0 {
+		return stub.DelState(key)
+	}
+	return stub.write("", key, value)
+}
+
+func (stub *testStub) DelState(key string) error {
+	return stub.write("", key, nil)
+}
+
+func (stub *testStub) PutPrivateData(collection string, key string, value []byte) error {
+	if len(value) == 0 {
+		return errors.New("value of private data cannot be empty")
+	}
+	return stub.write(collection, key, value)
+}
+
+func (stub *testStub) DelPrivateData(collection string, key string) error {
+	return stub.write(collection, key, nil)
+}
+
+func (stub *testStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value := stub.PvtState[collection][key]
+	if value == nil {
+		return nil, nil
+	}
+	sum := sha256.Sum256(value)
+	return sum[:], nil
+}
+
+func (stub *testStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.privateRange(collection, func(key string) bool { return strings.HasPrefix(key, prefix) }), nil
+}
+
+func (stub *testStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return stub.privateRange(collection, func(key string) bool {
+		return key >= startKey && (endKey == "" || key < endKey) && !strings.HasPrefix(key, "\x00")
+	}), nil
+}
+
+// privateRange iterates in key order over the committed keys of a collection that match
+func (stub *testStub) privateRange(collection string, match func(string) bool) *testIterator {
+	var keys []string
+	for key := range stub.PvtState[collection] {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	iterator := &testIterator{}
+	for _, key := range keys {
+		iterator.results = append(iterator.results, &queryresult.KV{Key: key, Value: stub.PvtState[collection][key]})
+	}
+	return iterator
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &testHistoryIterator{modifications: stub.history[key]}, nil
+}
+
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	stub.events = append(stub.events, name)
+	stub.payload = payload
+	return nil
+}
+
+// privateKeys returns the number of keys stored in every private collection
+func (stub *testStub) privateKeys() map[string]int {
+	counts := map[string]int{}
+	for collection, values := range stub.PvtState {
+		counts[collection] = len(values)
+	}
+	return counts
+}
+
+type testIterator struct {
+	results []*queryresult.KV
+}
+
+func (iterator *testIterator) HasNext() bool {
+	return len(iterator.results) > 0
+}
+
+func (iterator *testIterator) Next() (*queryresult.KV, error) {
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+func (iterator *testIterator) Close() error {
+	return nil
+}
+
+type testHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (iterator *testHistoryIterator) HasNext() bool {
+	return len(iterator.modifications) > 0
+}
+
+func (iterator *testHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	modification := iterator.modifications[0]
+	iterator.modifications = iterator.modifications[1:]
+	return modification, nil
+}
+
+func (iterator *testHistoryIterator) Close() error {
+	return nil
+}
+
+// testIdentity is a client identity with an enrollment ID, which is also the common
+// name of its certificate, an MSP and a viriot.role attribute
+type testIdentity struct {
+	id    string
+	mspID string
+	role  string
+}
+
+func (identity *testIdentity) GetID() (string, error) {
+	return identity.id, nil
+}
+
+func (identity *testIdentity) GetMSPID() (string, error) {
+	return identity.mspID, nil
+}
+
+func (identity *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName != ATTR_ROLE || identity.role == "" {
+		return "", false, nil
+	}
+	return identity.role, true, nil
+}
+
+func (identity *testIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	if value, found, _ := identity.GetAttributeValue(attrName); !found || value != attrValue {
+		return forbidden("", "", "attribute "+attrName+" is not "+attrValue)
+	}
+	return nil
+}
+
+func (identity *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: identity.id}}, nil
+}
+
+// newTestContext returns a context of the given stub and identity
+func newTestContext(stub *testStub, id string, mspID string, role string) *contractapi.TransactionContext {
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testIdentity{id: id, mspID: mspID, role: role})
+	return ctx
+}
+
+// errorCode returns the Code of a ChaincodeError, "" for nil and the message of
+// any other error, so that tables can compare outcomes with a single field
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*ChaincodeError); ok {
+		return e.Code
+	}
+	return err.Error()
+}
+
+// putTestSilo stores a running VirtualSilo of mspID, owned by the identity named
+// after its tenant
+func putTestSilo(t *testing.T, ctx contractapi.TransactionContextInterface, VSiloID string, mspID string) {
+	tenantID, _, err := parseVSiloID(VSiloID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	silo := VirtualSilo{VSiloID: VSiloID, TenantID: tenantID, FlavourID: "mqtt-f", Owner: tenantID, OwnerMSPID: mspID, Status: STATUS_RUNNING}
+	if err := putVirtualSiloState(ctx, &silo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putTestVThings stores a running ThingVisor of mspID with the given vThing names
+func putTestVThings(t *testing.T, ctx contractapi.TransactionContextInterface, thingVisorID string, mspID string, names ...string) {
+	thingVisor := ThingVisor{ThingVisorID: thingVisorID, OwnerMSPID: mspID, Status: STATUS_RUNNING}
+	if err := putThingVisorState(ctx, &thingVisor); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		vThing := VThingTV{ID: thingVisorID + "/" + name, Label: name, Type: "temperature"}
+		if err := putVThingState(ctx, &vThing); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	CollectionHistory string = "collectionHistory"
+
+	historyObject     string = "history"
+	historyUserObject string = "historyUser"
+
+	// historyTimeFormat has a fixed width so that keys sort chronologically
+	historyTimeFormat string = "2006-01-02T15:04:05.000000000Z"
+)
+
+// historyEntityPrefixes maps the node name prefixes used in LogGraph to the asset kinds
+var historyEntityPrefixes = map[string]string{
+	"thingvisor-":   NODE_THINGVISOR,
+	"vthing-":       NODE_VTHING,
+	"flavour-":      NODE_FLAVOUR,
+	"silo-":         NODE_VSILO,
+	"agreement-":    NODE_AGREEMENT,
+	"quota-":        NODE_QUOTA,
+	"offer-":        NODE_OFFER,
+	"subscription-": NODE_SUBSCRIPTION,
+}
+
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, internalError("", "", err)
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// historyEntities returns the assets touched by a transaction, as [kind, id] pairs,
+// from the nodes of its graph
+func historyEntities(nodes []LogGraph) [][2]string {
+	var entities [][2]string
+	seen := map[string]bool{}
+	for _, edge := range nodes {
+		for _, node := range []string{edge.Source, edge.Target} {
+			for prefix, kind := range historyEntityPrefixes {
+				if !strings.HasPrefix(node, prefix) || seen[node] {
+					continue
+				}
+				seen[node] = true
+				entities = append(entities, [2]string{kind, strings.TrimPrefix(node, prefix)})
+			}
+		}
+	}
+	return entities
+}
+
+// recordHistory persists a History record in CollectionHistory once for every asset
+// it touches and once for the user who submitted the transaction
+func recordHistory(ctx contractapi.TransactionContextInterface, history *History) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return internalError("", "", err)
+	}
+	timestamp := now.Format(historyTimeFormat)
+	var keys []string
+	for _, entity := range historyEntities(history.LogGraphs) {
+		key, err := ctx.GetStub().CreateCompositeKey(historyObject, []string{entity[0], entity[1], timestamp, history.TxID})
+		if err != nil {
+			return internalError(entity[0], entity[1], err)
+		}
+		keys = append(keys, key)
+	}
+	if history.UserID != "" {
+		key, err := ctx.GetStub().CreateCompositeKey(historyUserObject, []string{history.UserID, timestamp, history.TxID})
+		if err != nil {
+			return internalError(NODE_USER, history.UserID, err)
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if err := ctx.GetStub().PutPrivateData(CollectionHistory, key, data); err != nil {
+			return internalError("", "", err)
+		}
+	}
+	return nil
+}
+
+// parseTimeRange parses the optional RFC 3339 bounds of a history query
+func parseTimeRange(kind string, from string, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if from != "" {
+		if start, err = time.Parse(time.RFC3339, from); err != nil {
+			return start, end, invalidArgument(kind, "", "from", "from must be an RFC 3339 timestamp")
+		}
+	}
+	if to != "" {
+		if end, err = time.Parse(time.RFC3339, to); err != nil {
+			return start, end, invalidArgument(kind, "", "to", "to must be an RFC 3339 timestamp")
+		}
+	}
+	return start, end, nil
+}
+
+// readHistory returns the History records stored under a partial key whose
+// timestamp attribute, at position timeIndex, lies within [from, to]
+func readHistory(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, timeIndex int, from string, to string) ([]History, error) {
+	start, end, err := parseTimeRange(objectType, from, to)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionHistory, objectType, attributes)
+	if err != nil {
+		return nil, internalError(objectType, "", err)
+	}
+	results := []History{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(objectType, "", err)
+		}
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, internalError(objectType, queryResponse.Key, err)
+		}
+		if len(keyAttributes) <= timeIndex {
+			continue
+		}
+		timestamp, err := time.Parse(historyTimeFormat, keyAttributes[timeIndex])
+		if err != nil {
+			return nil, internalError(objectType, queryResponse.Key, err)
+		}
+		if (!start.IsZero() && timestamp.Before(start)) || (!end.IsZero() && timestamp.After(end)) {
+			continue
+		}
+		var history History
+		if err := json.Unmarshal(queryResponse.Value, &history); err != nil {
+			return nil, internalError(objectType, queryResponse.Key, err)
+		}
+		results = append(results, history)
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(objectType, "", err)
+	}
+	return results, nil
+}
+
+// hideSilos removes from History records the edges of silos the caller cannot see,
+// as GetProvenanceGraph leaves them out, and drops the records that no longer have
+// an edge of the node they were read for
+func hideSilos(ctx contractapi.TransactionContextInterface, node string, results []History) ([]History, error) {
+	if isAdmin(ctx) {
+		return results, nil
+	}
+	visible := map[string]bool{}
+	canSee := func(id string) (bool, error) {
+		if !strings.HasPrefix(id, "silo-") {
+			return true, nil
+		}
+		if seen, ok := visible[id]; ok {
+			return seen, nil
+		}
+		graphNode, err := getGraphNode(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		visible[id] = graphNode != nil && canSeeGraphNode(ctx, graphNode)
+		return visible[id], nil
+	}
+	filtered := []History{}
+	for _, history := range results {
+		edges := []LogGraph{}
+		touched := false
+		for _, edge := range history.LogGraphs {
+			sourceVisible, err := canSee(edge.Source)
+			if err != nil {
+				return nil, err
+			}
+			targetVisible, err := canSee(edge.Target)
+			if err != nil {
+				return nil, err
+			}
+			if sourceVisible && targetVisible {
+				edges = append(edges, edge)
+				touched = touched || edge.Source == node || edge.Target == node
+			}
+		}
+		if touched {
+			history.LogGraphs = edges
+			filtered = append(filtered, history)
+		}
+	}
+	return filtered, nil
+}
+
+// GetHistoryForEntity returns, oldest first, the History records of an asset between
+// the optional RFC 3339 bounds from and to. entityType is one of thingvisor, vthing,
+// flavour, virtualsilo, agreement, offer, subscription or quota, the ID of a quota
+// being its tenant ID. Unless the caller is an admin, the history of a silo of
+// another owner is refused and, in the history of every asset, the edges of such
+// silos are left out together with the records only about them.
+func (s *SmartContract) GetHistoryForEntity(ctx contractapi.TransactionContextInterface, entityType string, entityID string, from string, to string) ([]History, error) {
+	prefix := ""
+	for p, kind := range historyEntityPrefixes {
+		if kind == entityType {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return nil, invalidArgument(entityType, entityID, "entityType", "entityType must be one of thingvisor, vthing, flavour, virtualsilo, agreement, offer, subscription, quota")
+	}
+	if entityID == "" {
+		return nil, invalidArgument(entityType, entityID, "entityID", "entityID must not be empty")
+	}
+	if entityType == NODE_VSILO && !isAdmin(ctx) {
+		node, err := getGraphNode(ctx, prefix+entityID)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil && !canSeeGraphNode(ctx, node) {
+			return nil, forbidden(NODE_VSILO, entityID, "VirtualSilo "+entityID+" is owned by another identity")
+		}
+	}
+	results, err := readHistory(ctx, historyObject, []string{entityType, entityID}, 2, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return hideSilos(ctx, prefix+entityID, results)
+}
+
+// GetHistoryByUser returns, oldest first, the History records of the transactions
+// submitted by a user between the optional RFC 3339 bounds from and to. Only admins
+// can read the history of other users.
+func (s *SmartContract) GetHistoryByUser(ctx contractapi.TransactionContextInterface, userID string, from string, to string) ([]History, error) {
+	if userID == "" {
+		return nil, invalidArgument(NODE_USER, userID, "userID", "userID must not be empty")
+	}
+	callerID, _ := ctx.GetClientIdentity().GetID()
+	if userID != callerID && !isAdmin(ctx) {
+		return nil, forbidden(NODE_USER, userID, "only admins can read the history of other users")
+	}
+	return readHistory(ctx, historyUserObject, []string{userID}, 1, from, to)
+}
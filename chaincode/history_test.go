@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGetHistoryForEntityHidesSilosOfOtherOwners(t *testing.T) {
+	stub := newTestStub()
+	for i, tenant := range []string{"tenant1", "tenant2"} {
+		ctx := newTestContext(stub, tenant, "Org2MSP", ROLE_CONSUMER)
+		silo := "silo-" + tenant + "_home"
+		err := stub.invoke("tx"+strconv.Itoa(i+1), testTime+int64(i+1), func() error {
+			return emitHistory(ctx, &History{
+				EventName: "AddVThingVSilo",
+				UserID:    tenant,
+				UserMSPID: "Org2MSP",
+				LogGraphs: []LogGraph{
+					{Source: "tenant-" + tenant, SourceType: NODE_USER, Target: silo, TargetType: NODE_VSILO},
+					{Source: silo, SourceType: NODE_VSILO, Target: "vthing-air/co2", TargetType: NODE_VTHING},
+				},
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		caller     string
+		role       string
+		entityType string
+		entityID   string
+		edges      [][]string
+		code       string
+	}{
+		{name: "vThing seen by a tenant", caller: "tenant1", role: ROLE_CONSUMER, entityType: NODE_VTHING, entityID: "air/co2", edges: [][]string{
+			{"tenant-tenant1", "silo-tenant1_home"}, {"silo-tenant1_home", "vthing-air/co2"},
+		}},
+		{name: "vThing seen by an admin", caller: "admin2", role: ROLE_ADMIN, entityType: NODE_VTHING, entityID: "air/co2", edges: [][]string{
+			{"tenant-tenant1", "silo-tenant1_home"}, {"silo-tenant1_home", "vthing-air/co2"},
+			{"tenant-tenant2", "silo-tenant2_home"}, {"silo-tenant2_home", "vthing-air/co2"},
+		}},
+		{name: "own silo", caller: "tenant2", role: ROLE_CONSUMER, entityType: NODE_VSILO, entityID: "tenant2_home", edges: [][]string{
+			{"tenant-tenant2", "silo-tenant2_home"}, {"silo-tenant2_home", "vthing-air/co2"},
+		}},
+		{name: "silo of another tenant", caller: "tenant1", role: ROLE_CONSUMER, entityType: NODE_VSILO, entityID: "tenant2_home", code: CODE_FORBIDDEN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestContext(stub, tt.caller, "Org2MSP", tt.role)
+			results, err := (&SmartContract{}).GetHistoryForEntity(ctx, tt.entityType, tt.entityID, "", "")
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			var edges [][]string
+			for _, history := range results {
+				for _, edge := range history.LogGraphs {
+					edges = append(edges, []string{edge.Source, edge.Target})
+				}
+			}
+			if !reflect.DeepEqual(edges, tt.edges) {
+				t.Errorf("edges = %v, want %v", edges, tt.edges)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// StatusTransition records a single lifecycle change of a ThingVisor, Flavour or VirtualSilo
+type StatusTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason,omitempty"`
+	Time      string `json:"time"`
+	TxID      string `json:"tx_id"`
+	UserID    string `json:"user_id"`
+	UserMSPID string `json:"user_mspid"`
+}
+
+// statusTransitions lists, per asset kind, the states reachable from each state.
+// A state without an entry is terminal: the asset can only be deleted.
+var statusTransitions = map[string]map[string][]string{
+	NODE_THINGVISOR: {
+		STATUS_PENDING:  {STATUS_RUNNING, STATUS_FAILED},
+		STATUS_RUNNING:  {STATUS_STOPPING, STATUS_FAILED},
+		STATUS_STOPPING: {STATUS_STOPPED, STATUS_FAILED},
+		STATUS_FAILED:   {STATUS_STOPPING},
+	},
+	NODE_VSILO: {
+		STATUS_PENDING:  {STATUS_RUNNING, STATUS_FAILED},
+		STATUS_RUNNING:  {STATUS_STOPPING, STATUS_FAILED},
+		STATUS_STOPPING: {STATUS_STOPPED, STATUS_FAILED},
+		STATUS_FAILED:   {STATUS_STOPPING},
+	},
+	NODE_FLAVOUR: {
+		STATUS_PENDING: {STATUS_READY, STATUS_FAILED},
+	},
+}
+
+func canTransition(kind string, from string, to string) bool {
+	for _, next := range statusTransitions[kind][from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func newStatusTransition(ctx contractapi.TransactionContextInterface, from string, to string, reason string) (StatusTransition, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return StatusTransition{}, err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return StatusTransition{
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		Time:      now,
+		TxID:      ctx.GetStub().GetTxID(),
+		UserID:    userID,
+		UserMSPID: userMSPID,
+	}, nil
+}
+
+// initStatus puts a newly created asset in the pending state and opens its status history
+func initStatus(ctx contractapi.TransactionContextInterface, status *string, history *[]StatusTransition) error {
+	transition, err := newStatusTransition(ctx, "", STATUS_PENDING, "")
+	if err != nil {
+		return err
+	}
+	*status = STATUS_PENDING
+	*history = []StatusTransition{transition}
+	return nil
+}
+
+// transitionStatus moves an asset to the requested state if the lifecycle of its kind allows it
+func transitionStatus(ctx contractapi.TransactionContextInterface, kind string, id string, status *string, history *[]StatusTransition, to string, reason string) error {
+	if !canTransition(kind, *status, to) {
+		return invalidState(kind, id, kind+" "+id+" cannot move from '"+*status+"' to '"+to+"'")
+	}
+	transition, err := newStatusTransition(ctx, *status, to, reason)
+	if err != nil {
+		return err
+	}
+	*status = to
+	*history = append(*history, transition)
+	return nil
+}
+
+// checkStatusUnchanged rejects documents that try to change the status outside of a transition transaction
+func checkStatusUnchanged(kind string, id string, current string, requested string) error {
+	if requested != "" && requested != current {
+		return invalidArgument(kind, id, "status", "status of "+kind+" "+id+" is '"+current+"', use the lifecycle transactions to change it")
+	}
+	return nil
+}
+
+func setThingVisorStatus(ctx contractapi.TransactionContextInterface, eventName string, ThingVisorID string, to string, reason string) error {
+	thingVisor, err := getThingVisorState(ctx, ThingVisorID)
+	if err != nil {
+		return err
+	}
+	if err := transitionStatus(ctx, NODE_THINGVISOR, ThingVisorID, &thingVisor.Status, &thingVisor.StatusHistory, to, reason); err != nil {
+		return err
+	}
+	if err := putThingVisorState(ctx, thingVisor); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return SetHistory(ctx, eventName, []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "thingvisor-" + ThingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+	}, userID, userMSPID)
+}
+
+// RunThingVisor marks a pending ThingVisor as deployed and running
+func (s *SmartContract) RunThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) error {
+	return setThingVisorStatus(ctx, "RunThingVisor", ThingVisorID, STATUS_RUNNING, "")
+}
+
+// StopThingVisor starts the shutdown of a running (or failed) ThingVisor
+func (s *SmartContract) StopThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string) error {
+	return setThingVisorStatus(ctx, "StopThingVisor", ThingVisorID, STATUS_STOPPING, "")
+}
+
+// MarkThingVisorStopped records that a stopping ThingVisor has been torn down
+func (s *SmartContract) MarkThingVisorStopped(ctx contractapi.TransactionContextInterface, ThingVisorID string) error {
+	return setThingVisorStatus(ctx, "MarkThingVisorStopped", ThingVisorID, STATUS_STOPPED, "")
+}
+
+// FailThingVisor records that the deployment or shutdown of a ThingVisor failed
+func (s *SmartContract) FailThingVisor(ctx contractapi.TransactionContextInterface, ThingVisorID string, reason string) error {
+	return setThingVisorStatus(ctx, "FailThingVisor", ThingVisorID, STATUS_FAILED, reason)
+}
+
+func setFlavourStatus(ctx contractapi.TransactionContextInterface, eventName string, flavourID string, to string, reason string) error {
+	flavour, err := getFlavourState(ctx, flavourID)
+	if err != nil {
+		return err
+	}
+	if err := transitionStatus(ctx, NODE_FLAVOUR, flavourID, &flavour.Status, &flavour.StatusHistory, to, reason); err != nil {
+		return err
+	}
+	if err := putFlavourState(ctx, flavour); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return SetHistory(ctx, eventName, []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "flavour-" + flavourID, SourceType: NODE_USER, TargetType: NODE_FLAVOUR},
+	}, userID, userMSPID)
+}
+
+// MarkFlavourReady records that the images and yaml files of a pending Flavour have been stored
+func (s *SmartContract) MarkFlavourReady(ctx contractapi.TransactionContextInterface, flavourID string) error {
+	return setFlavourStatus(ctx, "MarkFlavourReady", flavourID, STATUS_READY, "")
+}
+
+// FailFlavour records that a pending Flavour could not be prepared
+func (s *SmartContract) FailFlavour(ctx contractapi.TransactionContextInterface, flavourID string, reason string) error {
+	return setFlavourStatus(ctx, "FailFlavour", flavourID, STATUS_FAILED, reason)
+}
+
+func setVirtualSiloStatus(ctx contractapi.TransactionContextInterface, eventName string, VSiloID string, to string, reason string) error {
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return err
+	}
+	if err := transitionStatus(ctx, NODE_VSILO, VSiloID, &silo.Status, &silo.StatusHistory, to, reason); err != nil {
+		return err
+	}
+	if err := putVirtualSiloState(ctx, silo); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return SetHistory(ctx, eventName, []LogGraph{
+		{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+		{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+		{Source: "tenant-" + userID, Target: "silo-" + VSiloID, SourceType: NODE_USER, TargetType: NODE_VSILO},
+	}, userID, userMSPID)
+}
+
+// RunVirtualSilo marks a pending VirtualSilo as deployed and running
+func (s *SmartContract) RunVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string) error {
+	return setVirtualSiloStatus(ctx, "RunVirtualSilo", VSiloID, STATUS_RUNNING, "")
+}
+
+// StopVirtualSilo starts the shutdown of a running (or failed) VirtualSilo
+func (s *SmartContract) StopVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string) error {
+	return setVirtualSiloStatus(ctx, "StopVirtualSilo", VSiloID, STATUS_STOPPING, "")
+}
+
+// MarkVirtualSiloStopped records that a stopping VirtualSilo has been torn down
+func (s *SmartContract) MarkVirtualSiloStopped(ctx contractapi.TransactionContextInterface, VSiloID string) error {
+	return setVirtualSiloStatus(ctx, "MarkVirtualSiloStopped", VSiloID, STATUS_STOPPED, "")
+}
+
+// FailVirtualSilo records that the deployment or shutdown of a VirtualSilo failed
+func (s *SmartContract) FailVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string, reason string) error {
+	return setVirtualSiloStatus(ctx, "FailVirtualSilo", VSiloID, STATUS_FAILED, reason)
+}
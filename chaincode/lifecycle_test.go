@@ -0,0 +1,179 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestThingVisorLifecycle(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "provider7", "Org1MSP", ROLE_PROVIDER)
+	thingVisor := ThingVisor{ThingVisorID: "camera", OwnerMSPID: "Org1MSP"}
+	if err := initStatus(ctx, &thingVisor.Status, &thingVisor.StatusHistory); err != nil {
+		t.Fatal(err)
+	}
+	if err := putThingVisorState(ctx, &thingVisor); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+	contract := &SmartContract{}
+	steps := []struct {
+		name   string
+		call   func() error
+		code   string
+		status string
+	}{
+		{name: "stop a pending ThingVisor", call: func() error { return contract.StopThingVisor(ctx, "camera") }, code: CODE_INVALID_STATE, status: STATUS_PENDING},
+		{name: "run", call: func() error { return contract.RunThingVisor(ctx, "camera") }, status: STATUS_RUNNING},
+		{name: "run twice", call: func() error { return contract.RunThingVisor(ctx, "camera") }, code: CODE_INVALID_STATE, status: STATUS_RUNNING},
+		{name: "stopped without stopping", call: func() error { return contract.MarkThingVisorStopped(ctx, "camera") }, code: CODE_INVALID_STATE, status: STATUS_RUNNING},
+		{name: "stop", call: func() error { return contract.StopThingVisor(ctx, "camera") }, status: STATUS_STOPPING},
+		{name: "fail while stopping", call: func() error { return contract.FailThingVisor(ctx, "camera", "pod is stuck") }, status: STATUS_FAILED},
+		{name: "stop a failed ThingVisor", call: func() error { return contract.StopThingVisor(ctx, "camera") }, status: STATUS_STOPPING},
+		{name: "stopped", call: func() error { return contract.MarkThingVisorStopped(ctx, "camera") }, status: STATUS_STOPPED},
+		{name: "run a stopped ThingVisor", call: func() error { return contract.RunThingVisor(ctx, "camera") }, code: CODE_INVALID_STATE, status: STATUS_STOPPED},
+		{name: "missing ThingVisor", call: func() error { return contract.RunThingVisor(ctx, "lidar") }, code: CODE_NOT_FOUND, status: STATUS_STOPPED},
+	}
+	transitions := 1
+	for i, step := range steps {
+		err := stub.invoke("tx"+strconv.Itoa(i+1), testTime+int64(i+1), step.call)
+		if code := errorCode(err); code != step.code {
+			t.Fatalf("%s: error code = %q, want %q (%v)", step.name, code, step.code, err)
+		}
+		if err == nil {
+			transitions++
+		}
+		stored, err := getThingVisorState(ctx, "camera")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != step.status || len(stored.StatusHistory) != transitions {
+			t.Fatalf("%s: status %q with %d transitions, want %q with %d", step.name, stored.Status, len(stored.StatusHistory), step.status, transitions)
+		}
+	}
+
+	stored, _ := getThingVisorState(ctx, "camera")
+	failure := stored.StatusHistory[3]
+	want := StatusTransition{From: STATUS_STOPPING, To: STATUS_FAILED, Reason: "pod is stuck", Time: rfc3339(testTime + 6), TxID: "tx6", UserID: "provider7", UserMSPID: "Org1MSP"}
+	if failure != want {
+		t.Errorf("transition = %+v, want %+v", failure, want)
+	}
+}
+
+func TestFlavourLifecycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		fail   bool
+		code   string
+		want   string
+	}{
+		{name: "ready", status: STATUS_PENDING, want: STATUS_READY},
+		{name: "failed", status: STATUS_PENDING, fail: true, want: STATUS_FAILED},
+		{name: "ready twice", status: STATUS_READY, code: CODE_INVALID_STATE, want: STATUS_READY},
+		{name: "fail a ready Flavour", status: STATUS_READY, fail: true, code: CODE_INVALID_STATE, want: STATUS_READY},
+		{name: "ready after a failure", status: STATUS_FAILED, code: CODE_INVALID_STATE, want: STATUS_FAILED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "provider7", "Org1MSP", ROLE_PROVIDER)
+			flavour := Flavour{FlavourID: "ngsild-f", Status: tt.status}
+			if err := putFlavourState(ctx, &flavour); err != nil {
+				t.Fatal(err)
+			}
+			stub.MockTransactionEnd("tx0")
+			err := stub.invoke("tx1", testTime+60, func() error {
+				if tt.fail {
+					return (&SmartContract{}).FailFlavour(ctx, "ngsild-f", "image not found")
+				}
+				return (&SmartContract{}).MarkFlavourReady(ctx, "ngsild-f")
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if stored, _ := getFlavourState(ctx, "ngsild-f"); stored.Status != tt.want {
+				t.Errorf("status = %q, want %q", stored.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestVirtualSiloLifecycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		caller string
+		role   string
+		call   func(*SmartContract, *contractapi.TransactionContext) error
+		code   string
+	}{
+		{name: "run a pending silo", status: STATUS_PENDING, caller: "tenant5", role: ROLE_CONSUMER, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.RunVirtualSilo(ctx, "tenant5_home")
+		}},
+		{name: "stop a pending silo", status: STATUS_PENDING, caller: "tenant5", role: ROLE_CONSUMER, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.StopVirtualSilo(ctx, "tenant5_home")
+		}, code: CODE_INVALID_STATE},
+		{name: "fail a running silo", status: STATUS_RUNNING, caller: "tenant5", role: ROLE_CONSUMER, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.FailVirtualSilo(ctx, "tenant5_home", "out of memory")
+		}},
+		{name: "silo of another tenant", status: STATUS_RUNNING, caller: "tenant6", role: ROLE_CONSUMER, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.StopVirtualSilo(ctx, "tenant5_home")
+		}, code: CODE_FORBIDDEN},
+		{name: "admin stops a silo", status: STATUS_RUNNING, caller: "admin1", role: ROLE_ADMIN, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.StopVirtualSilo(ctx, "tenant5_home")
+		}},
+		{name: "stopped silo", status: STATUS_STOPPED, caller: "tenant5", role: ROLE_CONSUMER, call: func(c *SmartContract, ctx *contractapi.TransactionContext) error {
+			return c.RunVirtualSilo(ctx, "tenant5_home")
+		}, code: CODE_INVALID_STATE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			owner := newTestContext(stub, "tenant5", "Org2MSP", ROLE_CONSUMER)
+			silo := VirtualSilo{VSiloID: "tenant5_home", TenantID: "tenant5", FlavourID: "ngsild-f", Owner: "tenant5", OwnerMSPID: "Org2MSP", Status: tt.status}
+			if err := putVirtualSiloState(owner, &silo); err != nil {
+				t.Fatal(err)
+			}
+			stub.MockTransactionEnd("tx0")
+			ctx := newTestContext(stub, tt.caller, "Org2MSP", tt.role)
+			err := stub.invoke("tx1", testTime+60, func() error { return tt.call(&SmartContract{}, ctx) })
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			stored, err := getVirtualSiloState(owner, "tenant5_home")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := len(stored.StatusHistory) == 1; changed != (tt.code == "") {
+				t.Errorf("status history = %+v", stored.StatusHistory)
+			}
+			if changed := stored.Status != tt.status; changed != (tt.code == "") {
+				t.Errorf("status = %q, was %q", stored.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckStatusUnchanged(t *testing.T) {
+	tests := []struct {
+		requested string
+		code      string
+	}{
+		{requested: ""},
+		{requested: STATUS_RUNNING},
+		{requested: STATUS_STOPPED, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		err := checkStatusUnchanged(NODE_THINGVISOR, "camera", STATUS_RUNNING, tt.requested)
+		if code := errorCode(err); code != tt.code {
+			t.Errorf("requested %q: error code = %q, want %q", tt.requested, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const implicitCollectionPrefix string = "_implicit_org_"
+
+// OrgIndexEntry is kept in the shared collection for every VirtualSilo and binding,
+// whose records live in the implicit collection of the organization of their owner.
+// It reserves the ID across organizations and tells where the record is.
+type OrgIndexEntry struct {
+	VSiloID    string `json:"vSiloID"`
+	VThingID   string `json:"vThingID,omitempty"`
+	OwnerMSPID string `json:"ownerMSPID"`
+	Collection string `json:"collection"`
+}
+
+func implicitCollection(mspID string) string {
+	return implicitCollectionPrefix + mspID
+}
+
+// callerCollection returns the implicit collection of the organization of the caller
+func callerCollection(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", internalError("", "", err)
+	}
+	return implicitCollection(mspID), nil
+}
+
+// putOrgRecord writes a record to the implicit collection of its owner organization
+// and its index entry to the shared indexCollection under the same key
+func putOrgRecord(ctx contractapi.TransactionContextInterface, indexCollection string, key string, entry OrgIndexEntry, data []byte) error {
+	entry.Collection = implicitCollection(entry.OwnerMSPID)
+	if err := putPrivateAsset(ctx, entry.Collection, key, data); err != nil {
+		return err
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(indexCollection, key, entryJSON)
+}
+
+// getOrgRecord looks a key up in the shared indexCollection and reads the record from
+// the implicit collection it points to. It returns nil when the key is not indexed.
+// Only the peers of the owner organization hold its implicit collection, so records
+// of other organizations cannot be read by anyone, admins included; the forbidden
+// error names the organization that keeps the record.
+func getOrgRecord(ctx contractapi.TransactionContextInterface, indexCollection string, key string, kind string, id string) ([]byte, error) {
+	entryJSON, err := ctx.GetStub().GetPrivateData(indexCollection, key)
+	if err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	if entryJSON == nil {
+		return nil, nil
+	}
+	var entry OrgIndexEntry
+	if err := json.Unmarshal(entryJSON, &entry); err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Collection != collection {
+		return nil, forbidden(kind, id, kind+" "+id+" is kept by organization "+entry.OwnerMSPID)
+	}
+	data, err := ctx.GetStub().GetPrivateData(entry.Collection, key)
+	if err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	return data, nil
+}
+
+// deleteOrgRecord removes a record from the implicit collection of its owner
+// organization and its index entry. Only the owner organization can delete the
+// record, so the caller is refused for records of other organizations instead of
+// orphaning them in their collection.
+func deleteOrgRecord(ctx contractapi.TransactionContextInterface, indexCollection string, key string, ownerMSPID string, kind string, id string) error {
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return err
+	}
+	if collection != implicitCollection(ownerMSPID) {
+		return forbidden(kind, id, kind+" "+id+" is kept by organization "+ownerMSPID)
+	}
+	if err := deletePrivateAsset(ctx, collection, key); err != nil {
+		return internalError(kind, id, err)
+	}
+	if err := ctx.GetStub().DelPrivateData(indexCollection, key); err != nil {
+		return internalError(kind, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrgRecordsOfOtherOrganizations(t *testing.T) {
+	stub := newTestStub()
+	owner := newTestContext(stub, "tenant3", "Org3MSP", ROLE_CONSUMER)
+	key, err := vSiloKey(owner, "tenant3_lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(VirtualSilo{VSiloID: "tenant3_lab", TenantID: "tenant3", OwnerMSPID: "Org3MSP"})
+	if err := putOrgRecord(owner, CollectionvSilos, key, OrgIndexEntry{VSiloID: "tenant3_lab", OwnerMSPID: "Org3MSP"}, data); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+	before := stub.privateKeys()
+
+	admin := newTestContext(stub, "admin1", "Org1MSP", ROLE_ADMIN)
+	err = stub.invoke("tx1", testTime, func() error {
+		_, err := getOrgRecord(admin, CollectionvSilos, key, NODE_VSILO, "tenant3_lab")
+		return err
+	})
+	if errorCode(err) != CODE_FORBIDDEN || !strings.Contains(err.Error(), "Org3MSP") {
+		t.Errorf("read by another organization: %v, want FORBIDDEN naming Org3MSP", err)
+	}
+	err = stub.invoke("tx2", testTime, func() error {
+		return deleteOrgRecord(admin, CollectionvSilos, key, "Org3MSP", NODE_VSILO, "tenant3_lab")
+	})
+	if errorCode(err) != CODE_FORBIDDEN {
+		t.Errorf("deletion by another organization: %v, want FORBIDDEN", err)
+	}
+	if after := stub.privateKeys(); !reflect.DeepEqual(after, before) {
+		t.Errorf("private keys = %v, want %v", after, before)
+	}
+
+	if err := stub.invoke("tx3", testTime, func() error {
+		return deleteOrgRecord(owner, CollectionvSilos, key, "Org3MSP", NODE_VSILO, "tenant3_lab")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.PvtState[CollectionvSilos]) != 0 || len(stub.PvtState[implicitCollection("Org3MSP")]) != 0 {
+		t.Errorf("index %v and records %v remain", stub.PvtState[CollectionvSilos], stub.PvtState[implicitCollection("Org3MSP")])
+	}
+}
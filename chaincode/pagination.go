@@ -0,0 +1,257 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const MAX_PAGE_SIZE int32 = 200
+
+type ThingVisorPage struct {
+	Records      []ThingVisor `json:"records"`
+	Bookmark     string       `json:"bookmark"`
+	FetchedCount int32        `json:"fetchedCount"`
+}
+
+type VThingPage struct {
+	Records      []VThingTV `json:"records"`
+	Bookmark     string     `json:"bookmark"`
+	FetchedCount int32      `json:"fetchedCount"`
+}
+
+type FlavourPage struct {
+	Records      []Flavour `json:"records"`
+	Bookmark     string    `json:"bookmark"`
+	FetchedCount int32     `json:"fetchedCount"`
+}
+
+type VirtualSiloPage struct {
+	Records      []VirtualSilo `json:"records"`
+	Bookmark     string        `json:"bookmark"`
+	FetchedCount int32         `json:"fetchedCount"`
+}
+
+type VThingVSiloPage struct {
+	Records      []VThingVSilo `json:"records"`
+	Bookmark     string        `json:"bookmark"`
+	FetchedCount int32         `json:"fetchedCount"`
+}
+
+// decodeBookmark turns the opaque bookmark handed out with a page back into the
+// key the next page starts from; an empty bookmark starts from the beginning
+func decodeBookmark(kind string, pageSize int32, bookmark string) (string, error) {
+	if pageSize <= 0 || pageSize > MAX_PAGE_SIZE {
+		return "", invalidArgument(kind, "", "pageSize", "pageSize must be between 1 and "+strconv.Itoa(int(MAX_PAGE_SIZE)))
+	}
+	if bookmark == "" {
+		return "", nil
+	}
+	startKey, err := base64.RawURLEncoding.DecodeString(bookmark)
+	if err != nil || len(startKey) == 0 {
+		return "", invalidArgument(kind, "", "bookmark", "malformed bookmark")
+	}
+	return string(startKey), nil
+}
+
+// readPage hands the records of the iterator from startKey on to handle until
+// pageSize of them have been accepted, and returns the bookmark of the next page.
+// Private data cannot be queried with pagination, and composite keys cannot be
+// used as the start of a range, so keys before startKey are skipped here.
+func readPage(kind string, iterator shim.StateQueryIteratorInterface, startKey string, pageSize int32, handle func(key string, value []byte) (bool, error)) (string, int32, error) {
+	var fetchedCount int32
+	bookmark := ""
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return "", 0, internalError(kind, "", err)
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+		if fetchedCount == pageSize {
+			bookmark = base64.RawURLEncoding.EncodeToString([]byte(queryResponse.Key))
+			break
+		}
+		accepted, err := handle(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return "", 0, err
+		}
+		if accepted {
+			fetchedCount++
+		}
+	}
+	if err := iterator.Close(); err != nil {
+		return "", 0, internalError(kind, "", err)
+	}
+	return bookmark, fetchedCount, nil
+}
+
+func (s *SmartContract) GetAllThingVisorsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ThingVisorPage, error) {
+	startKey, err := decodeBookmark(NODE_THINGVISOR, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	tvIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionThingVisors, startKey, "")
+	if err != nil {
+		return nil, internalError(NODE_THINGVISOR, "", err)
+	}
+	page := ThingVisorPage{Records: []ThingVisor{}}
+	page.Bookmark, page.FetchedCount, err = readPage(NODE_THINGVISOR, tvIterator, startKey, pageSize, func(key string, value []byte) (bool, error) {
+		var thingVisor ThingVisor
+		if err := json.Unmarshal(value, &thingVisor); err != nil {
+			return false, internalError(NODE_THINGVISOR, key, err)
+		}
+		vThings, err := s.GetAllVThingOfThingVisor(ctx, thingVisor.ThingVisorID)
+		if err != nil {
+			return false, err
+		}
+		thingVisor.VThings = vThings
+		page.Records = append(page.Records, thingVisor)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (s *SmartContract) GetAllVThingsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*VThingPage, error) {
+	startKey, err := decodeBookmark(NODE_VTHING, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionvThingTVs, vThingTVObject, []string{vThingTVPrefix})
+	if err != nil {
+		return nil, internalError(NODE_VTHING, "", err)
+	}
+	page := VThingPage{Records: []VThingTV{}}
+	page.Bookmark, page.FetchedCount, err = readPage(NODE_VTHING, resultsIterator, startKey, pageSize, func(key string, value []byte) (bool, error) {
+		var vThingTV VThingTV
+		if err := json.Unmarshal(value, &vThingTV); err != nil {
+			return false, internalError(NODE_VTHING, "", err)
+		}
+		page.Records = append(page.Records, vThingTV)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (s *SmartContract) GetAllFlavoursWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*FlavourPage, error) {
+	startKey, err := decodeBookmark(NODE_FLAVOUR, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	flavourIterator, err := ctx.GetStub().GetPrivateDataByRange(CollectionFlavours, startKey, "")
+	if err != nil {
+		return nil, internalError(NODE_FLAVOUR, "", err)
+	}
+	page := FlavourPage{Records: []Flavour{}}
+	page.Bookmark, page.FetchedCount, err = readPage(NODE_FLAVOUR, flavourIterator, startKey, pageSize, func(key string, value []byte) (bool, error) {
+		var flavour Flavour
+		if err := json.Unmarshal(value, &flavour); err != nil {
+			return false, internalError(NODE_FLAVOUR, key, err)
+		}
+		page.Records = append(page.Records, flavour)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (s *SmartContract) GetAllVirtualSilosWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*VirtualSiloPage, error) {
+	startKey, err := decodeBookmark(NODE_VSILO, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	siloIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vSiloObject, []string{vSiloPrefix})
+	if err != nil {
+		return nil, internalError(NODE_VSILO, "", err)
+	}
+	page := VirtualSiloPage{Records: []VirtualSilo{}}
+	page.Bookmark, page.FetchedCount, err = readPage(NODE_VSILO, siloIterator, startKey, pageSize, func(key string, value []byte) (bool, error) {
+		var silo VirtualSilo
+		if err := json.Unmarshal(value, &silo); err != nil {
+			return false, internalError(NODE_VSILO, "", err)
+		}
+		if !canAccessSilo(ctx, silo.Owner) {
+			return false, nil
+		}
+		page.Records = append(page.Records, silo)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func readVThingVSiloPage(ctx contractapi.TransactionContextInterface, attributes []string, pageSize int32, bookmark string) (*VThingVSiloPage, error) {
+	startKey, err := decodeBookmark(vThingVSiloObject, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, vThingVSiloObject, attributes)
+	if err != nil {
+		return nil, internalError(vThingVSiloObject, "", err)
+	}
+	page := VThingVSiloPage{Records: []VThingVSilo{}}
+	page.Bookmark, page.FetchedCount, err = readPage(vThingVSiloObject, resultsIterator, startKey, pageSize, func(key string, value []byte) (bool, error) {
+		var vThingVSilo VThingVSilo
+		if err := json.Unmarshal(value, &vThingVSilo); err != nil {
+			return false, internalError(vThingVSiloObject, "", err)
+		}
+		if !canAccessSilo(ctx, vThingVSilo.Owner) || !vThingVSilo.active(now) {
+			return false, nil
+		}
+		page.Records = append(page.Records, vThingVSilo)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (s *SmartContract) GetVThingVSilosByVSiloIDWithPagination(ctx contractapi.TransactionContextInterface, VSiloID string, pageSize int32, bookmark string) (*VThingVSiloPage, error) {
+	silo, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSiloAccess(ctx, silo); err != nil {
+		return nil, err
+	}
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return nil, err
+	}
+	return readVThingVSiloPage(ctx, []string{vThingVSiloPrefix, tenantID, vSiloName}, pageSize, bookmark)
+}
+
+func (s *SmartContract) GetVThingVSilosByTenantIDWithPagination(ctx contractapi.TransactionContextInterface, TenantID string, pageSize int32, bookmark string) (*VThingVSiloPage, error) {
+	return readVThingVSiloPage(ctx, []string{vThingVSiloPrefix, TenantID}, pageSize, bookmark)
+}
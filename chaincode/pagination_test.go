@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+func TestReadPage(t *testing.T) {
+	keys := []string{"a1", "a2", "b1", "b2", "c1"}
+	iterator := func() *testIterator {
+		it := &testIterator{}
+		for _, key := range keys {
+			it.results = append(it.results, &queryresult.KV{Key: key, Value: []byte(key)})
+		}
+		return it
+	}
+	tests := []struct {
+		name     string
+		startKey string
+		pageSize int32
+		skip     string
+		records  []string
+		next     string
+	}{
+		{name: "first page", pageSize: 2, records: []string{"a1", "a2"}, next: "b1"},
+		{name: "from a bookmark", startKey: "b1", pageSize: 2, records: []string{"b1", "b2"}, next: "c1"},
+		{name: "last page", startKey: "c1", pageSize: 2, records: []string{"c1"}},
+		{name: "page ends with the last key", startKey: "b1", pageSize: 3, records: []string{"b1", "b2", "c1"}},
+		{name: "start key between keys", startKey: "a3", pageSize: 1, records: []string{"b1"}, next: "b2"},
+		{name: "rejected records do not count", pageSize: 2, skip: "a", records: []string{"b1", "b2"}, next: "c1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []string
+			bookmark, fetchedCount, err := readPage(NODE_VTHING, iterator(), tt.startKey, tt.pageSize, func(key string, value []byte) (bool, error) {
+				if tt.skip != "" && strings.HasPrefix(key, tt.skip) {
+					return false, nil
+				}
+				records = append(records, string(value))
+				return true, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, tt.records) || fetchedCount != int32(len(tt.records)) {
+				t.Errorf("records = %v (%d fetched), want %v", records, fetchedCount, tt.records)
+			}
+			next, err := decodeBookmark(NODE_VTHING, tt.pageSize, bookmark)
+			if err != nil || next != tt.next {
+				t.Errorf("bookmark resumes at %q (%v), want %q", next, err, tt.next)
+			}
+		})
+	}
+}
+
+func TestDecodeBookmark(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int32
+		bookmark string
+		code     string
+	}{
+		{name: "first page", pageSize: 1},
+		{name: "largest page", pageSize: MAX_PAGE_SIZE},
+		{name: "empty page", pageSize: 0, code: CODE_INVALID_ARGUMENT},
+		{name: "page too large", pageSize: MAX_PAGE_SIZE + 1, code: CODE_INVALID_ARGUMENT},
+		{name: "malformed bookmark", pageSize: 10, bookmark: "not a bookmark!", code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeBookmark(NODE_FLAVOUR, tt.pageSize, tt.bookmark)
+			if code := errorCode(err); code != tt.code {
+				t.Errorf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestGetAllVThingsWithPagination(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "provider4", "Org1MSP", ROLE_PROVIDER)
+	putTestVThings(t, ctx, "air", "Org1MSP", "co2", "humidity", "pm25", "pressure", "ozone")
+	stub.MockTransactionEnd("tx0")
+	contract := &SmartContract{}
+	var IDs []string
+	var sizes []int32
+	bookmark := ""
+	for pages := 0; pages == 0 || bookmark != ""; pages++ {
+		if pages > 5 {
+			t.Fatalf("bookmark %q does not advance", bookmark)
+		}
+		page, err := contract.GetAllVThingsWithPagination(ctx, 2, bookmark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vThing := range page.Records {
+			IDs = append(IDs, vThing.ID)
+		}
+		sizes = append(sizes, page.FetchedCount)
+		bookmark = page.Bookmark
+	}
+	if want := []int32{2, 2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("page sizes = %v, want %v", sizes, want)
+	}
+	if want := []string{"air/co2", "air/humidity", "air/ozone", "air/pm25", "air/pressure"}; !reflect.DeepEqual(IDs, want) {
+		t.Errorf("vThings = %v, want %v", IDs, want)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// immutableFields lists, per asset kind, the fields a merge patch may not change.
+// Status and version bookkeeping is maintained by the chaincode itself.
+var immutableFields = map[string][]string{
+	NODE_THINGVISOR: {"thingVisorID", "ownerMSPID", "creationTime", "status", "statusHistory", "vThings", "version", "lastModified", "lastModifiedBy"},
+	NODE_VTHING:     {"id", "version", "lastModified", "lastModifiedBy"},
+	NODE_FLAVOUR:    {"flavourID", "creationTime", "status", "statusHistory", "version", "lastModified", "lastModifiedBy"},
+	NODE_VSILO:      {"vSiloID", "vSiloName", "tenantID", "flavourID", "owner", "ownerMSPID", "creationTime", "status", "statusHistory", "version", "lastModified", "lastModifiedBy"},
+}
+
+// mergePatch applies an RFC 7386 merge patch to a decoded JSON document
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for field, value := range patchObject {
+		if value == nil {
+			delete(targetObject, field)
+		} else {
+			targetObject[field] = mergePatch(targetObject[field], value)
+		}
+	}
+	return targetObject
+}
+
+// applyMergePatch merges patch into the current state of an asset and decodes the
+// result into patched, so that it is checked against the struct like a full
+// document. It returns the top-level fields whose value changed.
+func applyMergePatch(kind string, id string, current interface{}, patch string, patched interface{}) ([]string, error) {
+	var patchDocument interface{}
+	if err := decodeStrict(kind, id, patch, &patchDocument); err != nil {
+		return nil, err
+	}
+	patchObject, ok := patchDocument.(map[string]interface{})
+	if !ok {
+		return nil, invalidArgument(kind, id, "", "merge patch must be a JSON object")
+	}
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	var original map[string]interface{}
+	if err := json.Unmarshal(currentJSON, &original); err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(currentJSON, &merged); err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	merged = mergePatch(merged, patchObject).(map[string]interface{})
+	changed := []string{}
+	for field := range patchObject {
+		if reflect.DeepEqual(original[field], merged[field]) {
+			continue
+		}
+		if containsString(immutableFields[kind], field) {
+			return nil, invalidArgument(kind, id, field, field+" of a "+kind+" cannot be changed")
+		}
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, internalError(kind, id, err)
+	}
+	if err := decodeStrict(kind, id, string(mergedJSON), patched); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// PatchThingVisor applies an RFC 7386 merge patch, e.g. {"tvDescription":"new"}, to a
+// ThingVisor. A null value removes a field; identifiers, creation time and status
+// cannot be patched. An expectedVersion of 0 skips the version check.
+func (s *SmartContract) PatchThingVisor(ctx contractapi.TransactionContextInterface, id string, patch string, expectedVersion int) error {
+	current, err := getThingVisorState(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_THINGVISOR, id, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	var thingVisor ThingVisor
+	changed, err := applyMergePatch(NODE_THINGVISOR, id, current, patch, &thingVisor)
+	if err != nil {
+		return err
+	}
+	if err := validateThingVisor(id, &thingVisor); err != nil {
+		return err
+	}
+	if err := takeSecret(ctx, NODE_THINGVISOR, id, &thingVisor.Params, &thingVisor.ParamsSalt, current.Params, current.ParamsSalt); err != nil {
+		return err
+	}
+	if thingVisor.Params != current.Params || thingVisor.ParamsSalt != current.ParamsSalt {
+		changed = append(changed, TRANSIENT_PARAMS)
+		sort.Strings(changed)
+	}
+	if err := putThingVisorState(ctx, &thingVisor); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return emitHistory(ctx, &History{
+		EventName: "PatchThingVisor",
+		UserID:    userID,
+		UserMSPID: userMSPID,
+		LogGraphs: []LogGraph{
+			{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+			{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+			{Source: "user-" + userID, Target: "thingvisor-" + id, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+		},
+		ChangedFields: changed,
+	})
+}
+
+// PatchVThing applies an RFC 7386 merge patch, e.g. {"endpoint":null}, to a vThing.
+// An expectedVersion of 0 skips the version check.
+func (s *SmartContract) PatchVThing(ctx contractapi.TransactionContextInterface, VThingID string, patch string, expectedVersion int) error {
+	current, err := getVThingState(ctx, VThingID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_VTHING, VThingID, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	thingVisorID, _, err := parseVThingID(VThingID)
+	if err != nil {
+		return err
+	}
+	var vThing VThingTV
+	changed, err := applyMergePatch(NODE_VTHING, VThingID, current, patch, &vThing)
+	if err != nil {
+		return err
+	}
+	if err := validateVThing(thingVisorID, &vThing); err != nil {
+		return err
+	}
+	if err := putVThingState(ctx, &vThing); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return emitHistory(ctx, &History{
+		EventName: "PatchVThing",
+		UserID:    userID,
+		UserMSPID: userMSPID,
+		LogGraphs: []LogGraph{
+			{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+			{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+			{Source: "user-" + userID, Target: "thingvisor-" + thingVisorID, SourceType: NODE_USER, TargetType: NODE_THINGVISOR},
+			{Source: "thingvisor-" + thingVisorID, Target: "vthing-" + VThingID, SourceType: NODE_THINGVISOR, TargetType: NODE_VTHING},
+		},
+		ChangedFields: changed,
+	})
+}
+
+// PatchFlavour applies an RFC 7386 merge patch, e.g. {"flavourDescription":"new"}, to a Flavour.
+// An expectedVersion of 0 skips the version check.
+func (s *SmartContract) PatchFlavour(ctx contractapi.TransactionContextInterface, flavourID string, patch string, expectedVersion int) error {
+	current, err := getFlavourState(ctx, flavourID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_FLAVOUR, flavourID, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	var flavour Flavour
+	changed, err := applyMergePatch(NODE_FLAVOUR, flavourID, current, patch, &flavour)
+	if err != nil {
+		return err
+	}
+	if err := validateFlavour(flavourID, &flavour); err != nil {
+		return err
+	}
+	if err := putFlavourState(ctx, &flavour); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return emitHistory(ctx, &History{
+		EventName: "PatchFlavour",
+		UserID:    userID,
+		UserMSPID: userMSPID,
+		LogGraphs: []LogGraph{
+			{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+			{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+			{Source: "user-" + userID, Target: "flavour-" + flavourID, SourceType: NODE_USER, TargetType: NODE_FLAVOUR},
+		},
+		ChangedFields: changed,
+	})
+}
+
+// PatchVirtualSilo applies an RFC 7386 merge patch, e.g. {"ipAddress":"10.0.0.1"}, to a
+// VirtualSilo of the caller. Tenant, flavour and owner cannot be patched. An
+// expectedVersion of 0 skips the version check.
+func (s *SmartContract) PatchVirtualSilo(ctx contractapi.TransactionContextInterface, VSiloID string, patch string, expectedVersion int) error {
+	current, err := getVirtualSiloState(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	if err := checkSiloAccess(ctx, current); err != nil {
+		return err
+	}
+	if err := checkVersion(NODE_VSILO, VSiloID, current.Version, expectedVersion); err != nil {
+		return err
+	}
+	var silo VirtualSilo
+	changed, err := applyMergePatch(NODE_VSILO, VSiloID, current, patch, &silo)
+	if err != nil {
+		return err
+	}
+	if err := validateVirtualSilo(VSiloID, &silo); err != nil {
+		return err
+	}
+	if err := takeSecret(ctx, NODE_VSILO, VSiloID, &silo.FlavourParams, &silo.FlavourParamsSalt, current.FlavourParams, current.FlavourParamsSalt); err != nil {
+		return err
+	}
+	if silo.FlavourParams != current.FlavourParams || silo.FlavourParamsSalt != current.FlavourParamsSalt {
+		changed = append(changed, TRANSIENT_FLAVOUR_PARAMS)
+		sort.Strings(changed)
+	}
+	if err := putVirtualSiloState(ctx, &silo); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	return emitHistory(ctx, &History{
+		EventName: "PatchVirtualSilo",
+		UserID:    userID,
+		UserMSPID: userMSPID,
+		LogGraphs: []LogGraph{
+			{Source: userMSPID + "-consumer", Target: "tenant-" + userID, SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER},
+			{Source: "tenant-" + userID, Target: userMSPID + "-consumer", SourceType: NODE_USER, TargetType: NODE_ORG_CONSUMER},
+			{Source: "tenant-" + userID, Target: "silo-" + VSiloID, SourceType: NODE_USER, TargetType: NODE_VSILO},
+		},
+		ChangedFields: changed,
+	})
+}
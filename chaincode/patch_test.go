@@ -0,0 +1,68 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatchVThing(t *testing.T) {
+	current := VThingTV{ID: "weather/Tokyo", Label: "Tokyo", Type: "temperature", Endpoint: "http://tokyo", Version: 3}
+	tests := []struct {
+		name     string
+		patch    string
+		code     string
+		changed  []string
+		label    string
+		endpoint string
+	}{
+		{name: "replace", patch: `{"label":"Tokyo-1"}`, changed: []string{"label"}, label: "Tokyo-1", endpoint: "http://tokyo"},
+		{name: "null removes", patch: `{"endpoint":null}`, changed: []string{"endpoint"}, label: "Tokyo", endpoint: ""},
+		{name: "same value is not a change", patch: `{"label":"Tokyo"}`, changed: []string{}, label: "Tokyo", endpoint: "http://tokyo"},
+		{name: "changes are sorted", patch: `{"type":"humidity","label":"x"}`, changed: []string{"label", "type"}, label: "x", endpoint: "http://tokyo"},
+		{name: "immutable field", patch: `{"id":"weather/Osaka"}`, code: CODE_INVALID_ARGUMENT},
+		{name: "immutable field unchanged", patch: `{"version":3}`, changed: []string{}, label: "Tokyo", endpoint: "http://tokyo"},
+		{name: "unknown field", patch: `{"colour":"red"}`, code: CODE_INVALID_ARGUMENT},
+		{name: "not an object", patch: `["label"]`, code: CODE_INVALID_ARGUMENT},
+		{name: "not JSON", patch: `{label`, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patched VThingTV
+			changed, err := applyMergePatch(NODE_VTHING, current.ID, current, tt.patch, &patched)
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				return
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if patched.Label != tt.label || patched.Endpoint != tt.endpoint {
+				t.Errorf("patched = %+v, want label %q and endpoint %q", patched, tt.label, tt.endpoint)
+			}
+			if patched.ID != current.ID || patched.Version != current.Version {
+				t.Errorf("patched = %+v, lost the fields of %+v", patched, current)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatchNested(t *testing.T) {
+	current := ThingVisor{ThingVisorID: "weather", MQTTDataBroker: &MQTTProfile{IP: "10.0.0.1", Port: "1883"}}
+	var patched ThingVisor
+	changed, err := applyMergePatch(NODE_THINGVISOR, "weather", current, `{"MQTTDataBroker":{"port":"1884"}}`, &patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"MQTTDataBroker"}) {
+		t.Errorf("changed = %v", changed)
+	}
+	if patched.MQTTDataBroker == nil || patched.MQTTDataBroker.IP != "10.0.0.1" || patched.MQTTDataBroker.Port != "1884" {
+		t.Errorf("MQTTDataBroker = %+v, want the ip kept and the port replaced", patched.MQTTDataBroker)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	POLICY_PERMIT string = "permit"
+	POLICY_DENY   string = "deny"
+
+	ACTION_USE string = "use"
+)
+
+// policyOperands lists the left operands a constraint can test. Those evaluated when a
+// vThing is bound map to true; the others, like count per day, are duties the platform
+// enforces while the binding exists.
+var policyOperands = map[string]bool{
+	"purpose":      true,
+	"flavour":      true,
+	"organization": true,
+	"tenant":       true,
+	"dateTime":     true,
+	"count":        false,
+}
+
+var policyOperators = []string{"eq", "neq", "isAnyOf", "isNoneOf", "lt", "lteq", "gt", "gteq"}
+
+// UsagePolicy states, after ODRL, what a consumer may and may not do with the data
+// of a vThing, e.g. {"permission":[{"action":"use","constraint":[{"leftOperand":
+// "purpose","operator":"isAnyOf","rightOperand":["research"]}]}],"prohibition":
+// [{"action":"distribute"}]}.
+type UsagePolicy struct {
+	Permissions  []PolicyRule `json:"permission,omitempty"`
+	Prohibitions []PolicyRule `json:"prohibition,omitempty"`
+}
+
+// PolicyRule applies to an action when all of its constraints hold
+type PolicyRule struct {
+	Action      string             `json:"action"`
+	Constraints []PolicyConstraint `json:"constraint,omitempty"`
+}
+
+// PolicyConstraint compares an operand, e.g. purpose, with the values in RightOperand.
+// Unit qualifies count constraints, e.g. "day".
+type PolicyConstraint struct {
+	LeftOperand  string   `json:"leftOperand"`
+	Operator     string   `json:"operator"`
+	RightOperand []string `json:"rightOperand"`
+	Unit         string   `json:"unit,omitempty"`
+}
+
+// PolicyEvaluation is the outcome of the policy of a vThing for a binding. Duties are
+// the rules left for the platform to enforce.
+type PolicyEvaluation struct {
+	Decision      string       `json:"decision"`
+	Reason        string       `json:"reason,omitempty"`
+	Purpose       string       `json:"purpose"`
+	FlavourID     string       `json:"flavourID"`
+	PolicyVersion int          `json:"policyVersion"`
+	Duties        []PolicyRule `json:"duties"`
+	EvaluatedAt   string       `json:"evaluatedAt"`
+}
+
+// policyRequest holds the operand values known when a vThing is bound
+type policyRequest map[string]string
+
+func validatePolicy(id string, policy *UsagePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for _, rule := range append(append([]PolicyRule{}, policy.Permissions...), policy.Prohibitions...) {
+		if rule.Action == "" {
+			return invalidArgument(NODE_VTHING, id, "policy", "every policy rule needs an action")
+		}
+		for _, constraint := range rule.Constraints {
+			if _, ok := policyOperands[constraint.LeftOperand]; !ok {
+				return invalidArgument(NODE_VTHING, id, "policy", "unknown leftOperand '"+constraint.LeftOperand+"'")
+			}
+			if !containsString(policyOperators, constraint.Operator) {
+				return invalidArgument(NODE_VTHING, id, "policy", "operator must be one of "+strings.Join(policyOperators, ", "))
+			}
+			if len(constraint.RightOperand) == 0 {
+				return invalidArgument(NODE_VTHING, id, "policy", "constraint on "+constraint.LeftOperand+" needs a rightOperand")
+			}
+		}
+	}
+	return nil
+}
+
+// compareOperand orders a request value against a constraint value, as times for
+// dateTime and as numbers for count
+func compareOperand(leftOperand string, value string, operand string) (int, bool) {
+	if leftOperand == "dateTime" {
+		left, err1 := time.Parse(time.RFC3339, value)
+		right, err2 := time.Parse(time.RFC3339, operand)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		if left.Before(right) {
+			return -1, true
+		} else if left.After(right) {
+			return 1, true
+		}
+		return 0, true
+	}
+	left, err1 := strconv.ParseFloat(value, 64)
+	right, err2 := strconv.ParseFloat(operand, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	if left < right {
+		return -1, true
+	} else if left > right {
+		return 1, true
+	}
+	return 0, true
+}
+
+// holds evaluates a constraint against the request. A constraint without a
+// rightOperand, which validatePolicy refuses, never holds.
+func (c *PolicyConstraint) holds(request policyRequest) bool {
+	if len(c.RightOperand) == 0 {
+		return false
+	}
+	value := request[c.LeftOperand]
+	switch c.Operator {
+	case "eq":
+		return value == c.RightOperand[0]
+	case "neq":
+		return value != c.RightOperand[0]
+	case "isAnyOf":
+		return containsString(c.RightOperand, value)
+	case "isNoneOf":
+		return !containsString(c.RightOperand, value)
+	}
+	order, ok := compareOperand(c.LeftOperand, value, c.RightOperand[0])
+	if !ok {
+		return false
+	}
+	switch c.Operator {
+	case "lt":
+		return order < 0
+	case "lteq":
+		return order <= 0
+	case "gt":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+// applies tells whether every constraint of a rule that can be evaluated at binding
+// time holds, and whether the rule has constraints left to the platform
+func (r *PolicyRule) applies(request policyRequest) (bool, bool) {
+	deferred := false
+	for _, constraint := range r.Constraints {
+		if !policyOperands[constraint.LeftOperand] {
+			deferred = true
+			continue
+		}
+		if !constraint.holds(request) {
+			return false, deferred
+		}
+	}
+	return true, deferred
+}
+
+// evaluatePolicy decides whether a vThing may be bound for the request. A prohibition
+// of use that applies denies; otherwise, if the policy grants use, one of those
+// permissions must apply. Rules on other actions, and rules of use with constraints
+// that cannot be evaluated yet, such as a prohibition beyond a count, become duties
+// metered by the platform.
+func evaluatePolicy(policy *UsagePolicy, request policyRequest) PolicyEvaluation {
+	evaluation := PolicyEvaluation{Decision: POLICY_PERMIT, Duties: []PolicyRule{}}
+	if policy == nil {
+		return evaluation
+	}
+	for _, rule := range policy.Prohibitions {
+		if rule.Action != ACTION_USE {
+			evaluation.Duties = append(evaluation.Duties, rule)
+			continue
+		}
+		applies, deferred := rule.applies(request)
+		if !applies {
+			continue
+		}
+		if deferred {
+			evaluation.Duties = append(evaluation.Duties, rule)
+			continue
+		}
+		evaluation.Decision = POLICY_DENY
+		evaluation.Reason = "use is prohibited"
+		return evaluation
+	}
+	granted := false
+	permitted := false
+	for _, rule := range policy.Permissions {
+		if rule.Action != ACTION_USE {
+			evaluation.Duties = append(evaluation.Duties, rule)
+			continue
+		}
+		granted = true
+		applies, deferred := rule.applies(request)
+		if !applies || permitted {
+			continue
+		}
+		permitted = true
+		if deferred {
+			evaluation.Duties = append(evaluation.Duties, rule)
+		}
+	}
+	if granted && !permitted {
+		evaluation.Decision = POLICY_DENY
+		evaluation.Reason = "no permission of use applies"
+	}
+	return evaluation
+}
@@ -0,0 +1,91 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func useRule(constraints ...PolicyConstraint) PolicyRule {
+	return PolicyRule{Action: ACTION_USE, Constraints: constraints}
+}
+
+func constraint(leftOperand string, operator string, rightOperand ...string) PolicyConstraint {
+	return PolicyConstraint{LeftOperand: leftOperand, Operator: operator, RightOperand: rightOperand}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	request := policyRequest{
+		"purpose":      "research",
+		"flavour":      "mqtt-f",
+		"organization": "Org2MSP",
+		"tenant":       "tenant1",
+		"dateTime":     "2021-06-01T00:00:00Z",
+	}
+	perDay := constraint("count", "lteq", "100")
+	perDay.Unit = "day"
+	tests := []struct {
+		name     string
+		policy   *UsagePolicy
+		decision string
+		duties   int
+	}{
+		{name: "no policy", decision: POLICY_PERMIT},
+		{name: "empty policy", policy: &UsagePolicy{}, decision: POLICY_PERMIT},
+		{name: "permitted purpose", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "isAnyOf", "research", "education"))}}, decision: POLICY_PERMIT},
+		{name: "other purpose", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "isAnyOf", "marketing"))}}, decision: POLICY_DENY},
+		{name: "one permission applies", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("flavour", "eq", "ngsild-f")), useRule(constraint("tenant", "eq", "tenant1"))}}, decision: POLICY_PERMIT},
+		{name: "all constraints must hold", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "eq", "research"), constraint("organization", "neq", "Org2MSP"))}}, decision: POLICY_DENY},
+		{name: "prohibition applies", policy: &UsagePolicy{Prohibitions: []PolicyRule{useRule(constraint("organization", "isAnyOf", "Org2MSP"))}}, decision: POLICY_DENY},
+		{name: "prohibition does not apply", policy: &UsagePolicy{Prohibitions: []PolicyRule{useRule(constraint("organization", "isNoneOf", "Org2MSP"))}}, decision: POLICY_PERMIT},
+		{name: "prohibition wins over permission", policy: &UsagePolicy{Permissions: []PolicyRule{useRule()}, Prohibitions: []PolicyRule{useRule(constraint("tenant", "eq", "tenant1"))}}, decision: POLICY_DENY},
+		{name: "count prohibition is a duty", policy: &UsagePolicy{Prohibitions: []PolicyRule{useRule(constraint("count", "gt", "100"))}}, decision: POLICY_PERMIT, duties: 1},
+		{name: "count prohibition of another purpose", policy: &UsagePolicy{Prohibitions: []PolicyRule{useRule(constraint("purpose", "eq", "marketing"), constraint("count", "gt", "100"))}}, decision: POLICY_PERMIT},
+		{name: "count permission is a duty", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "eq", "research"), perDay)}}, decision: POLICY_PERMIT, duties: 1},
+		{name: "other actions are duties", policy: &UsagePolicy{Permissions: []PolicyRule{{Action: "aggregate"}}, Prohibitions: []PolicyRule{{Action: "distribute"}}}, decision: POLICY_PERMIT, duties: 2},
+		{name: "before a date", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("dateTime", "lt", "2022-01-01T00:00:00Z"))}}, decision: POLICY_PERMIT},
+		{name: "after a date", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("dateTime", "gteq", "2022-01-01T00:00:00Z"))}}, decision: POLICY_DENY},
+		{name: "malformed date", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("dateTime", "lt", "tomorrow"))}}, decision: POLICY_DENY},
+		{name: "empty rightOperand", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "eq"))}}, decision: POLICY_DENY},
+		{name: "empty rightOperand of a prohibition", policy: &UsagePolicy{Prohibitions: []PolicyRule{useRule(constraint("dateTime", "lt"))}}, decision: POLICY_PERMIT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluation := evaluatePolicy(tt.policy, request)
+			if evaluation.Decision != tt.decision {
+				t.Errorf("decision = %q (%s), want %q", evaluation.Decision, evaluation.Reason, tt.decision)
+			}
+			if evaluation.Decision == POLICY_DENY && evaluation.Reason == "" {
+				t.Error("a denial has no reason")
+			}
+			if evaluation.Decision == POLICY_PERMIT && len(evaluation.Duties) != tt.duties {
+				t.Errorf("duties = %+v, want %d", evaluation.Duties, tt.duties)
+			}
+		})
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *UsagePolicy
+		code   string
+	}{
+		{name: "no policy"},
+		{name: "valid", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "isAnyOf", "research"))}, Prohibitions: []PolicyRule{{Action: "distribute"}}}},
+		{name: "missing action", policy: &UsagePolicy{Prohibitions: []PolicyRule{{}}}, code: CODE_INVALID_ARGUMENT},
+		{name: "unknown leftOperand", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("colour", "eq", "red"))}}, code: CODE_INVALID_ARGUMENT},
+		{name: "unknown operator", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "like", "research"))}}, code: CODE_INVALID_ARGUMENT},
+		{name: "missing rightOperand", policy: &UsagePolicy{Permissions: []PolicyRule{useRule(constraint("purpose", "eq"))}}, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePolicy("weather/Tokyo", tt.policy)
+			if code := errorCode(err); code != tt.code {
+				t.Errorf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+		})
+	}
+}
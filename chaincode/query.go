@@ -0,0 +1,219 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// queryableFields lists, per asset kind, the fields a selector may refer to.
+// The common filters on the shared collections are backed by the indexes under
+// META-INF/statedb/couchdb.
+var queryableFields = map[string][]string{
+	NODE_THINGVISOR: {"thingVisorID", "status", "debug_mode", "creationTime", "ipAddress"},
+	NODE_VTHING:     {"id", "label", "type"},
+	NODE_FLAVOUR:    {"flavourID", "status", "creationTime"},
+	NODE_VSILO:      {"vSiloID", "tenantID", "flavourID", "status", "owner", "ownerMSPID", "creationTime"},
+}
+
+// queryDocTypes restricts the queries of the kinds kept in implicit collections,
+// which hold several kinds of records, to the docType of the kind
+var queryDocTypes = map[string]string{
+	NODE_VSILO: vSiloObject,
+}
+
+var selectorOperators = []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin", "$exists"}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// validateCondition checks the condition of a single field: either a scalar,
+// meaning equality, or an object of supported operators
+func validateCondition(kind string, field string, condition interface{}) error {
+	if isScalar(condition) {
+		return nil
+	}
+	operators, ok := condition.(map[string]interface{})
+	if !ok || len(operators) == 0 {
+		return invalidArgument(kind, "", field, "condition on "+field+" must be a string, number, boolean or an object of operators")
+	}
+	for operator, operand := range operators {
+		if !containsString(selectorOperators, operator) {
+			return invalidArgument(kind, "", field, "operator "+operator+" is not supported, use one of "+strings.Join(selectorOperators, ", "))
+		}
+		switch operator {
+		case "$in", "$nin":
+			values, ok := operand.([]interface{})
+			if !ok {
+				return invalidArgument(kind, "", field, operator+" on "+field+" needs an array")
+			}
+			for _, v := range values {
+				if !isScalar(v) {
+					return invalidArgument(kind, "", field, operator+" on "+field+" only accepts strings, numbers and booleans")
+				}
+			}
+		case "$exists":
+			if _, ok := operand.(bool); !ok {
+				return invalidArgument(kind, "", field, "$exists on "+field+" needs a boolean")
+			}
+		default:
+			if !isScalar(operand) {
+				return invalidArgument(kind, "", field, operator+" on "+field+" needs a string, number or boolean")
+			}
+		}
+	}
+	return nil
+}
+
+// buildQuery validates a selector received from a client and wraps it into a
+// CouchDB query. Only the fields of queryableFields and the operators of
+// selectorOperators are accepted; the conditions on several fields are combined with AND.
+func buildQuery(kind string, selector string) (string, error) {
+	var conditions map[string]interface{}
+	if err := decodeStrict(kind, "", selector, &conditions); err != nil {
+		return "", err
+	}
+	if len(conditions) == 0 {
+		return "", invalidArgument(kind, "", "", "selector must have at least one condition")
+	}
+	for field, condition := range conditions {
+		if !containsString(queryableFields[kind], field) {
+			return "", invalidArgument(kind, "", field, "field "+field+" cannot be queried, use one of "+strings.Join(queryableFields[kind], ", "))
+		}
+		if err := validateCondition(kind, field, condition); err != nil {
+			return "", err
+		}
+	}
+	if docType, ok := queryDocTypes[kind]; ok {
+		conditions["docType"] = docType
+	}
+	query, err := json.Marshal(map[string]interface{}{"selector": conditions})
+	if err != nil {
+		return "", internalError(kind, "", err)
+	}
+	return string(query), nil
+}
+
+func runQuery(ctx contractapi.TransactionContextInterface, kind string, collection string, selector string, handle func(key string, value []byte) error) error {
+	query, err := buildQuery(kind, selector)
+	if err != nil {
+		return err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataQueryResult(collection, query)
+	if err != nil {
+		return internalError(kind, "", err)
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return internalError(kind, "", err)
+		}
+		if err := handle(queryResponse.Key, queryResponse.Value); err != nil {
+			return err
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return internalError(kind, "", err)
+	}
+	return nil
+}
+
+// QueryThingVisors returns the ThingVisors matching the selector, e.g. {"status":"running"}
+func (s *SmartContract) QueryThingVisors(ctx contractapi.TransactionContextInterface, selector string) ([]ThingVisor, error) {
+	results := []ThingVisor{}
+	err := runQuery(ctx, NODE_THINGVISOR, CollectionThingVisors, selector, func(key string, value []byte) error {
+		var thingVisor ThingVisor
+		if err := json.Unmarshal(value, &thingVisor); err != nil {
+			return internalError(NODE_THINGVISOR, key, err)
+		}
+		vThings, err := s.GetAllVThingOfThingVisor(ctx, thingVisor.ThingVisorID)
+		if err != nil {
+			return err
+		}
+		thingVisor.VThings = vThings
+		results = append(results, thingVisor)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryVThings returns the vThings matching the selector, e.g. {"type":"temperature"}
+func (s *SmartContract) QueryVThings(ctx contractapi.TransactionContextInterface, selector string) ([]VThingTV, error) {
+	results := []VThingTV{}
+	err := runQuery(ctx, NODE_VTHING, CollectionvThingTVs, selector, func(key string, value []byte) error {
+		var vThingTV VThingTV
+		if err := json.Unmarshal(value, &vThingTV); err != nil {
+			return internalError(NODE_VTHING, "", err)
+		}
+		results = append(results, vThingTV)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryFlavours returns the Flavours matching the selector, e.g. {"status":"ready"}
+func (s *SmartContract) QueryFlavours(ctx contractapi.TransactionContextInterface, selector string) ([]Flavour, error) {
+	results := []Flavour{}
+	err := runQuery(ctx, NODE_FLAVOUR, CollectionFlavours, selector, func(key string, value []byte) error {
+		var flavour Flavour
+		if err := json.Unmarshal(value, &flavour); err != nil {
+			return internalError(NODE_FLAVOUR, key, err)
+		}
+		results = append(results, flavour)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryVirtualSilos returns the VirtualSilos of the caller (all of those of the
+// organization for an admin) matching the selector, e.g. {"flavourID":"Mqtt-base-f"}
+func (s *SmartContract) QueryVirtualSilos(ctx contractapi.TransactionContextInterface, selector string) ([]VirtualSilo, error) {
+	collection, err := callerCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := []VirtualSilo{}
+	err = runQuery(ctx, NODE_VSILO, collection, selector, func(key string, value []byte) error {
+		var silo VirtualSilo
+		if err := json.Unmarshal(value, &silo); err != nil {
+			return internalError(NODE_VSILO, "", err)
+		}
+		if canAccessSilo(ctx, silo.Owner) {
+			results = append(results, silo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
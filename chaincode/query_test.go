@@ -0,0 +1,47 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		selector string
+		query    string
+		code     string
+	}{
+		{name: "equality", kind: NODE_THINGVISOR, selector: `{"status":"running"}`, query: `{"selector":{"status":"running"}}`},
+		{name: "operators", kind: NODE_VTHING, selector: `{"type":{"$in":["temperature","humidity"]},"label":{"$ne":"test"}}`, query: `{"selector":{"label":{"$ne":"test"},"type":{"$in":["temperature","humidity"]}}}`},
+		{name: "boolean field", kind: NODE_THINGVISOR, selector: `{"debug_mode":true}`, query: `{"selector":{"debug_mode":true}}`},
+		{name: "docType of silos", kind: NODE_VSILO, selector: `{"flavourID":"mqtt-f","creationTime":{"$gte":"2021-01-01T00:00:00Z"}}`, query: `{"selector":{"creationTime":{"$gte":"2021-01-01T00:00:00Z"},"docType":"` + vSiloObject + `","flavourID":"mqtt-f"}}`},
+		{name: "field of another kind", kind: NODE_FLAVOUR, selector: `{"tenantID":"tenant1"}`, code: CODE_INVALID_ARGUMENT},
+		{name: "secret field", kind: NODE_THINGVISOR, selector: `{"params":{"$exists":true}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "docType set by the client", kind: NODE_VSILO, selector: `{"docType":"vThingVSilo"}`, code: CODE_INVALID_ARGUMENT},
+		{name: "combination operator", kind: NODE_VSILO, selector: `{"$or":[{"status":"running"},{"status":"failed"}]}`, code: CODE_INVALID_ARGUMENT},
+		{name: "regular expression", kind: NODE_VTHING, selector: `{"label":{"$regex":".*"}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "nested selector in $in", kind: NODE_VTHING, selector: `{"type":{"$in":[{"$gt":""}]}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "$in without an array", kind: NODE_VTHING, selector: `{"type":{"$in":"temperature"}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "$exists without a boolean", kind: NODE_FLAVOUR, selector: `{"status":{"$exists":"yes"}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "object operand", kind: NODE_FLAVOUR, selector: `{"status":{"$eq":{"$ne":"ready"}}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "empty condition", kind: NODE_FLAVOUR, selector: `{"status":{}}`, code: CODE_INVALID_ARGUMENT},
+		{name: "empty selector", kind: NODE_THINGVISOR, selector: `{}`, code: CODE_INVALID_ARGUMENT},
+		{name: "malformed selector", kind: NODE_THINGVISOR, selector: `{"status":`, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := buildQuery(tt.kind, tt.selector)
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if query != tt.query {
+				t.Errorf("query = %s, want %s", query, tt.query)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	CollectionQuotas string = "collectionQuotas"
+
+	tenantSilosObject string = "tenantSilos"
+	siloVThingsObject string = "siloVThings"
+
+	NODE_QUOTA string = "quota"
+)
+
+// TenantQuota limits the silos of a tenant and the vThings bound to each of them.
+// A limit of 0 means no limit; an empty AllowedFlavours allows every flavour.
+type TenantQuota struct {
+	TenantID          string   `json:"tenantID"`
+	MaxSilos          int      `json:"maxSilos"`
+	MaxVThingsPerSilo int      `json:"maxVThingsPerSilo"`
+	AllowedFlavours   []string `json:"allowedFlavours"`
+	Version           int      `json:"version"`
+	LastModified      string   `json:"lastModified"`
+	LastModifiedBy    string   `json:"lastModifiedBy"`
+}
+
+// TenantUsageAndQuota reports the silos of a tenant and the vThings bound to each
+// silo, as counted by the chaincode, next to the quota of the tenant, if any
+type TenantUsageAndQuota struct {
+	TenantID       string         `json:"tenantID"`
+	Silos          int            `json:"silos"`
+	VThingsPerSilo map[string]int `json:"vThingsPerSilo"`
+	Quota          *TenantQuota   `json:"quota"`
+}
+
+// getTenantQuota returns the quota of a tenant, or nil when the tenant has none
+func getTenantQuota(ctx contractapi.TransactionContextInterface, tenantID string) (*TenantQuota, error) {
+	data, err := ctx.GetStub().GetPrivateData(CollectionQuotas, tenantID)
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var quota TenantQuota
+	if err := json.Unmarshal(data, &quota); err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	return &quota, nil
+}
+
+func putTenantQuota(ctx contractapi.TransactionContextInterface, quota *TenantQuota) error {
+	if err := setLastModified(ctx, &quota.LastModified, &quota.LastModifiedBy); err != nil {
+		return err
+	}
+	quota.Version++
+	data, err := json.Marshal(quota)
+	if err != nil {
+		return internalError(NODE_QUOTA, quota.TenantID, err)
+	}
+	if err := putPrivateAsset(ctx, CollectionQuotas, quota.TenantID, data); err != nil {
+		return internalError(NODE_QUOTA, quota.TenantID, err)
+	}
+	return nil
+}
+
+func tenantSilosKey(ctx contractapi.TransactionContextInterface, tenantID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(tenantSilosObject, []string{tenantID})
+	if err != nil {
+		return "", internalError(NODE_QUOTA, tenantID, err)
+	}
+	return key, nil
+}
+
+func siloVThingsKey(ctx contractapi.TransactionContextInterface, VSiloID string) (string, error) {
+	tenantID, vSiloName, err := parseVSiloID(VSiloID)
+	if err != nil {
+		return "", err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(siloVThingsObject, []string{tenantID, vSiloName})
+	if err != nil {
+		return "", internalError(NODE_QUOTA, VSiloID, err)
+	}
+	return key, nil
+}
+
+func getCounter(ctx contractapi.TransactionContextInterface, key string) (int, error) {
+	data, err := ctx.GetStub().GetPrivateData(CollectionQuotas, key)
+	if err != nil {
+		return 0, internalError(NODE_QUOTA, "", err)
+	}
+	if data == nil {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, internalError(NODE_QUOTA, "", err)
+	}
+	return count, nil
+}
+
+// addToCounter adds delta to a counter key and returns the new count. Counters never
+// go below 0 and are removed when they reach it.
+func addToCounter(ctx contractapi.TransactionContextInterface, key string, delta int) (int, error) {
+	count, err := getCounter(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	count += delta
+	if count < 0 {
+		count = 0
+	}
+	if err := setCounter(ctx, key, count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// setCounter writes a count, removing the counter key when it is 0
+func setCounter(ctx contractapi.TransactionContextInterface, key string, count int) error {
+	if count <= 0 {
+		if err := ctx.GetStub().DelPrivateData(CollectionQuotas, key); err != nil {
+			return internalError(NODE_QUOTA, "", err)
+		}
+		return nil
+	}
+	if err := ctx.GetStub().PutPrivateData(CollectionQuotas, key, []byte(strconv.Itoa(count))); err != nil {
+		return internalError(NODE_QUOTA, "", err)
+	}
+	return nil
+}
+
+// reserveSilo counts a new silo of a tenant, refusing it when the quota of the tenant
+// does not allow the flavour or another silo
+func reserveSilo(ctx contractapi.TransactionContextInterface, tenantID string, VSiloID string, flavourID string) error {
+	quota, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if quota != nil && len(quota.AllowedFlavours) > 0 && !containsString(quota.AllowedFlavours, flavourID) {
+		return forbidden(NODE_VSILO, VSiloID, "the quota of tenant "+tenantID+" does not allow flavour "+flavourID)
+	}
+	key, err := tenantSilosKey(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	count, err := addToCounter(ctx, key, 1)
+	if err != nil {
+		return err
+	}
+	if quota != nil && quota.MaxSilos > 0 && count > quota.MaxSilos {
+		return forbidden(NODE_VSILO, VSiloID, "tenant "+tenantID+" has reached its quota of "+strconv.Itoa(quota.MaxSilos)+" silos")
+	}
+	return nil
+}
+
+// releaseSilo uncounts a deleted silo and the vThings bound to it
+func releaseSilo(ctx contractapi.TransactionContextInterface, tenantID string, VSiloID string) error {
+	key, err := tenantSilosKey(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if _, err := addToCounter(ctx, key, -1); err != nil {
+		return err
+	}
+	key, err = siloVThingsKey(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelPrivateData(CollectionQuotas, key); err != nil {
+		return internalError(NODE_QUOTA, VSiloID, err)
+	}
+	return nil
+}
+
+// reserveVThings counts new bindings of a silo, refusing them when the quota of the
+// tenant does not allow that many more vThings in the silo. Like releaseVThings, it
+// must be called once per silo in a transaction.
+func reserveVThings(ctx contractapi.TransactionContextInterface, tenantID string, VSiloID string, count int) error {
+	quota, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	key, err := siloVThingsKey(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	total, err := addToCounter(ctx, key, count)
+	if err != nil {
+		return err
+	}
+	if quota != nil && quota.MaxVThingsPerSilo > 0 && total > quota.MaxVThingsPerSilo {
+		return forbidden(NODE_VSILO, VSiloID, "tenant "+tenantID+" has reached its quota of "+strconv.Itoa(quota.MaxVThingsPerSilo)+" vThings per silo")
+	}
+	return nil
+}
+
+// releaseVThings uncounts the deleted bindings of a silo. Reads do not see the writes
+// of the same transaction, so all the bindings of a silo deleted by a transaction
+// must be released at once.
+func releaseVThings(ctx contractapi.TransactionContextInterface, VSiloID string, count int) error {
+	key, err := siloVThingsKey(ctx, VSiloID)
+	if err != nil {
+		return err
+	}
+	_, err = addToCounter(ctx, key, -count)
+	return err
+}
+
+// SetTenantQuota creates or replaces the quota of a tenant, given as a JSON document
+// such as {"maxSilos":2,"maxVThingsPerSilo":10,"allowedFlavours":["mqtt-f"]}.
+// Silos and bindings that already exist are kept when the quota is lowered. The quota
+// is rejected with CONFLICT unless expectedVersion is the version of the current
+// quota; an expectedVersion of 0 skips the check.
+func (s *SmartContract) SetTenantQuota(ctx contractapi.TransactionContextInterface, tenantID string, quotaData string, expectedVersion int) error {
+	if tenantID == "" || strings.Contains(tenantID, "_") {
+		return invalidArgument(NODE_QUOTA, tenantID, "tenantID", "tenantID must be non-empty and must not contain '_'")
+	}
+	current, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	currentVersion := 0
+	if current != nil {
+		currentVersion = current.Version
+	}
+	if err := checkVersion(NODE_QUOTA, tenantID, currentVersion, expectedVersion); err != nil {
+		return err
+	}
+	var quota TenantQuota
+	if err := decodeStrict(NODE_QUOTA, tenantID, quotaData, &quota); err != nil {
+		return err
+	}
+	if quota.TenantID != "" && quota.TenantID != tenantID {
+		return invalidArgument(NODE_QUOTA, tenantID, "tenantID", "tenantID does not match the quota document")
+	}
+	if quota.MaxSilos < 0 {
+		return invalidArgument(NODE_QUOTA, tenantID, "maxSilos", "maxSilos must not be negative")
+	}
+	if quota.MaxVThingsPerSilo < 0 {
+		return invalidArgument(NODE_QUOTA, tenantID, "maxVThingsPerSilo", "maxVThingsPerSilo must not be negative")
+	}
+	if quota.AllowedFlavours == nil {
+		quota.AllowedFlavours = []string{}
+	}
+	for _, flavourID := range quota.AllowedFlavours {
+		if flavourID == "" {
+			return invalidArgument(NODE_QUOTA, tenantID, "allowedFlavours", "allowedFlavours must not contain empty IDs")
+		}
+	}
+	quota.TenantID = tenantID
+	quota.Version = currentVersion
+	if err := putTenantQuota(ctx, &quota); err != nil {
+		return err
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := callerGraph(ctx, userID, userMSPID, ROLE_CONSUMER)
+	graph = append(graph, LogGraph{Source: graph[1].Source, Target: "quota-" + tenantID, SourceType: NODE_USER, TargetType: NODE_QUOTA})
+	return SetHistory(ctx, "SetTenantQuota", graph, userID, userMSPID)
+}
+
+// DeleteTenantQuota lifts every limit of a tenant. Its counts are kept.
+func (s *SmartContract) DeleteTenantQuota(ctx contractapi.TransactionContextInterface, tenantID string) error {
+	quota, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if quota == nil {
+		return notFound(NODE_QUOTA, tenantID)
+	}
+	if err := deletePrivateAsset(ctx, CollectionQuotas, tenantID); err != nil {
+		return internalError(NODE_QUOTA, tenantID, err)
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := callerGraph(ctx, userID, userMSPID, ROLE_CONSUMER)
+	graph = append(graph, LogGraph{Source: graph[1].Source, Target: "quota-" + tenantID, SourceType: NODE_USER, TargetType: NODE_DELETED})
+	return SetHistory(ctx, "DeleteTenantQuota", graph, userID, userMSPID)
+}
+
+// countIndexedByTenant counts the keys of the shared indexCollection under objectType
+// and the prefix for a tenant, by the attribute at position group of the key, or all
+// together under "" when group is negative
+func countIndexedByTenant(ctx contractapi.TransactionContextInterface, indexCollection string, objectType string, prefix string, tenantID string, group int) (map[string]int, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(indexCollection, objectType, []string{prefix, tenantID})
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	counts := map[string]int{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, tenantID, err)
+		}
+		name := ""
+		if group >= 0 {
+			_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				return nil, internalError(NODE_QUOTA, queryResponse.Key, err)
+			}
+			if len(keyAttributes) <= group {
+				continue
+			}
+			name = keyAttributes[group]
+		}
+		counts[name]++
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	return counts, nil
+}
+
+// RecountTenantUsage rebuilds the counts of a tenant from the shared indexes of its
+// silos and bindings, which also list those created before quotas were introduced,
+// and returns them with the quota of the tenant
+func (s *SmartContract) RecountTenantUsage(ctx contractapi.TransactionContextInterface, tenantID string) (*TenantUsageAndQuota, error) {
+	if tenantID == "" || strings.Contains(tenantID, "_") {
+		return nil, invalidArgument(NODE_QUOTA, tenantID, "tenantID", "tenantID must be non-empty and must not contain '_'")
+	}
+	quota, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	silos, err := countIndexedByTenant(ctx, CollectionvSilos, vSiloObject, vSiloPrefix, tenantID, -1)
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := countIndexedByTenant(ctx, CollectionvThingVSilos, vThingVSiloObject, vThingVSiloPrefix, tenantID, 2)
+	if err != nil {
+		return nil, err
+	}
+	key, err := tenantSilosKey(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setCounter(ctx, key, silos[""]); err != nil {
+		return nil, err
+	}
+	// counters of silos without bindings are removed, the others replaced
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionQuotas, siloVThingsObject, []string{tenantID})
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	var staleKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, tenantID, err)
+		}
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, queryResponse.Key, err)
+		}
+		if len(keyAttributes) == 2 && bindings[keyAttributes[1]] == 0 {
+			staleKeys = append(staleKeys, queryResponse.Key)
+		}
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	for _, staleKey := range staleKeys {
+		if err := setCounter(ctx, staleKey, 0); err != nil {
+			return nil, err
+		}
+	}
+	result := TenantUsageAndQuota{TenantID: tenantID, Silos: silos[""], VThingsPerSilo: map[string]int{}, Quota: quota}
+	for vSiloName, count := range bindings {
+		VSiloID := tenantID + "_" + vSiloName
+		key, err := siloVThingsKey(ctx, VSiloID)
+		if err != nil {
+			return nil, err
+		}
+		if err := setCounter(ctx, key, count); err != nil {
+			return nil, err
+		}
+		result.VThingsPerSilo[VSiloID] = count
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	graph := callerGraph(ctx, userID, userMSPID, ROLE_CONSUMER)
+	graph = append(graph, LogGraph{Source: graph[1].Source, Target: "quota-" + tenantID, SourceType: NODE_USER, TargetType: NODE_QUOTA})
+	if err := SetHistory(ctx, "RecountTenantUsage", graph, userID, userMSPID); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetTenantUsageAndQuota returns the counts kept for a tenant and its quota, which
+// is nil when the tenant has no limits. Silos created before quotas were introduced
+// are only counted once RecountTenantUsage has run for the tenant. Only the tenant
+// itself and admins can read them.
+func (s *SmartContract) GetTenantUsageAndQuota(ctx contractapi.TransactionContextInterface, tenantID string) (*TenantUsageAndQuota, error) {
+	if tenantID == "" {
+		return nil, invalidArgument(NODE_QUOTA, tenantID, "tenantID", "tenantID must not be empty")
+	}
+	if err := checkTenant(ctx, NODE_QUOTA, tenantID, tenantID); err != nil {
+		return nil, err
+	}
+	quota, err := getTenantQuota(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := tenantSilosKey(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	silos, err := getCounter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	result := TenantUsageAndQuota{TenantID: tenantID, Silos: silos, VThingsPerSilo: map[string]int{}, Quota: quota}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionQuotas, siloVThingsObject, []string{tenantID})
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, tenantID, err)
+		}
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, queryResponse.Key, err)
+		}
+		if len(keyAttributes) != 2 {
+			continue
+		}
+		count, err := strconv.Atoi(string(queryResponse.Value))
+		if err != nil {
+			return nil, internalError(NODE_QUOTA, queryResponse.Key, err)
+		}
+		result.VThingsPerSilo[tenantID+"_"+keyAttributes[1]] = count
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_QUOTA, tenantID, err)
+	}
+	return &result, nil
+}
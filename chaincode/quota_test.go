@@ -0,0 +1,292 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func setTestQuota(t *testing.T, ctx contractapi.TransactionContextInterface, quota TenantQuota) {
+	if err := putTenantQuota(ctx, &quota); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveSilo(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    *TenantQuota
+		existing int
+		flavour  string
+		code     string
+		count    int
+	}{
+		{name: "no quota", existing: 5, flavour: "mqtt-f", count: 6},
+		{name: "below the limit", quota: &TenantQuota{MaxSilos: 2}, existing: 1, flavour: "mqtt-f", count: 2},
+		{name: "at the limit", quota: &TenantQuota{MaxSilos: 2}, existing: 2, flavour: "mqtt-f", code: CODE_FORBIDDEN},
+		{name: "no limit", quota: &TenantQuota{}, existing: 9, flavour: "mqtt-f", count: 10},
+		{name: "allowed flavour", quota: &TenantQuota{AllowedFlavours: []string{"mqtt-f"}}, flavour: "mqtt-f", count: 1},
+		{name: "other flavour", quota: &TenantQuota{AllowedFlavours: []string{"mqtt-f"}}, flavour: "ngsild-f", code: CODE_FORBIDDEN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+			if tt.quota != nil {
+				tt.quota.TenantID = "tenant1"
+				setTestQuota(t, ctx, *tt.quota)
+			}
+			key, err := tenantSilosKey(ctx, "tenant1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := addToCounter(ctx, key, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+			stub.MockTransactionEnd("tx0")
+			err = stub.invoke("tx1", testTime, func() error {
+				return reserveSilo(ctx, "tenant1", "tenant1_silo", tt.flavour)
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			want := tt.count
+			if tt.code != "" {
+				want = tt.existing
+			}
+			if count, _ := getCounter(ctx, key); count != want {
+				t.Errorf("silos = %d, want %d", count, want)
+			}
+		})
+	}
+}
+
+func TestReserveAndReleaseVThings(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		existing int
+		delta    int
+		code     string
+		count    int
+	}{
+		{name: "reserve without limit", existing: 3, delta: 2, count: 5},
+		{name: "reserve up to the limit", max: 5, existing: 3, delta: 2, count: 5},
+		{name: "reserve over the limit", max: 5, existing: 3, delta: 3, code: CODE_FORBIDDEN},
+		{name: "release", max: 5, existing: 3, delta: -2, count: 1},
+		{name: "release never goes below 0", existing: 1, delta: -3, count: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+			setTestQuota(t, ctx, TenantQuota{TenantID: "tenant1", MaxVThingsPerSilo: tt.max})
+			key, err := siloVThingsKey(ctx, "tenant1_silo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := addToCounter(ctx, key, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+			stub.MockTransactionEnd("tx0")
+			err = stub.invoke("tx1", testTime, func() error {
+				if tt.delta > 0 {
+					return reserveVThings(ctx, "tenant1", "tenant1_silo", tt.delta)
+				}
+				return releaseVThings(ctx, "tenant1_silo", -tt.delta)
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				tt.count = tt.existing
+			}
+			if count, _ := getCounter(ctx, key); count != tt.count {
+				t.Errorf("vThings = %d, want %d", count, tt.count)
+			}
+			if _, stored := stub.PvtState[CollectionQuotas][key]; stored != (tt.count > 0) {
+				t.Errorf("counter stored = %v with a count of %d", stored, tt.count)
+			}
+		})
+	}
+}
+
+func TestSetTenantQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenantID string
+		quota    string
+		version  int
+		code     string
+	}{
+		{name: "create", tenantID: "tenant1", quota: `{"maxSilos":2,"maxVThingsPerSilo":10,"allowedFlavours":["mqtt-f"]}`},
+		{name: "replace at the current version", tenantID: "tenant1", quota: `{"maxSilos":3}`, version: 1},
+		{name: "stale version", tenantID: "tenant1", quota: `{"maxSilos":3}`, version: 2, code: CODE_CONFLICT},
+		{name: "tenant with '_'", tenantID: "tenant_1", quota: `{"maxSilos":2}`, code: CODE_INVALID_ARGUMENT},
+		{name: "other tenant in the document", tenantID: "tenant1", quota: `{"tenantID":"tenant2"}`, code: CODE_INVALID_ARGUMENT},
+		{name: "negative limit", tenantID: "tenant1", quota: `{"maxSilos":-1}`, code: CODE_INVALID_ARGUMENT},
+		{name: "empty flavour", tenantID: "tenant1", quota: `{"allowedFlavours":[""]}`, code: CODE_INVALID_ARGUMENT},
+		{name: "unknown field", tenantID: "tenant1", quota: `{"maxTenants":1}`, code: CODE_INVALID_ARGUMENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "admin", "Org1MSP", ROLE_ADMIN)
+			if tt.version > 0 {
+				setTestQuota(t, ctx, TenantQuota{TenantID: tt.tenantID, MaxSilos: 1})
+			}
+			stub.MockTransactionEnd("tx0")
+			err := stub.invoke("tx1", testTime, func() error {
+				return (&SmartContract{}).SetTenantQuota(ctx, tt.tenantID, tt.quota, tt.version)
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				return
+			}
+			quota, err := getTenantQuota(ctx, tt.tenantID)
+			if err != nil || quota == nil {
+				t.Fatalf("quota = %v, %v", quota, err)
+			}
+			if quota.Version != tt.version+1 || quota.AllowedFlavours == nil {
+				t.Errorf("quota = %+v, want version %d and a non-nil allowedFlavours", quota, tt.version+1)
+			}
+			if len(stub.events) != 1 || stub.events[0] != "SetTenantQuota" {
+				t.Errorf("events = %v", stub.events)
+			}
+			var history History
+			if err := json.Unmarshal(stub.payload, &history); err != nil {
+				t.Fatal(err)
+			}
+			caller := LogGraph{Source: "Org1MSP-consumer", Target: "tenant-admin", SourceType: NODE_ORG_CONSUMER, TargetType: NODE_USER}
+			quotaEdge := LogGraph{Source: "tenant-admin", Target: "quota-" + tt.tenantID, SourceType: NODE_USER, TargetType: NODE_QUOTA}
+			if len(history.LogGraphs) != 3 || history.LogGraphs[0] != caller || history.LogGraphs[2] != quotaEdge {
+				t.Errorf("graph = %+v, want the admin as a consumer-side caller", history.LogGraphs)
+			}
+		})
+	}
+}
+
+func TestGetTenantUsageAndQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		role   string
+		code   string
+	}{
+		{name: "tenant", caller: "tenant1", role: ROLE_CONSUMER},
+		{name: "admin", caller: "admin", role: ROLE_ADMIN},
+		{name: "other tenant", caller: "tenant2", role: ROLE_CONSUMER, code: CODE_FORBIDDEN},
+		{name: "provider", caller: "provider1", role: ROLE_PROVIDER, code: CODE_FORBIDDEN},
+	}
+	stub := newTestStub()
+	ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+	setTestQuota(t, ctx, TenantQuota{TenantID: "tenant1", MaxSilos: 2})
+	if err := reserveSilo(ctx, "tenant1", "tenant1_a", "mqtt-f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveVThings(ctx, "tenant1", "tenant1_a", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveVThings(ctx, "tenant10", "tenant10_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestContext(stub, tt.caller, "Org1MSP", tt.role)
+			usage, err := (&SmartContract{}).GetTenantUsageAndQuota(ctx, "tenant1")
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("error code = %q, want %q (%v)", code, tt.code, err)
+			}
+			if tt.code != "" {
+				return
+			}
+			if usage.Silos != 1 || len(usage.VThingsPerSilo) != 1 || usage.VThingsPerSilo["tenant1_a"] != 3 {
+				t.Errorf("usage = %+v", usage)
+			}
+			if usage.Quota == nil || usage.Quota.MaxSilos != 2 {
+				t.Errorf("quota = %+v", usage.Quota)
+			}
+		})
+	}
+}
+
+func TestDeleteMissingBindingKeepsCounter(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+	putTestSilo(t, ctx, "tenant1_silo", "Org1MSP")
+	if err := reserveVThings(ctx, "tenant1", "tenant1_silo", 2); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+	err := stub.invoke("tx1", testTime, func() error {
+		return (&SmartContract{}).DeleteVThingVSilo(ctx, "tenant1_silo", "weather/Tokyo")
+	})
+	if code := errorCode(err); code != CODE_NOT_FOUND {
+		t.Fatalf("error code = %q, want %q (%v)", code, CODE_NOT_FOUND, err)
+	}
+	key, _ := siloVThingsKey(ctx, "tenant1_silo")
+	if count, _ := getCounter(ctx, key); count != 2 {
+		t.Errorf("vThings = %d, want 2", count)
+	}
+}
+
+func TestRecountTenantUsage(t *testing.T) {
+	stub := newTestStub()
+	admin := newTestContext(stub, "admin", "Org2MSP", ROLE_ADMIN)
+	for _, VSiloID := range []string{"tenant8_home", "tenant8_office", "tenant9_home"} {
+		putTestSilo(t, admin, VSiloID, "Org2MSP")
+	}
+	for _, binding := range []VThingVSilo{
+		{TenantID: "tenant8", VSiloID: "tenant8_home", VThingID: "energy/meter1"},
+		{TenantID: "tenant8", VSiloID: "tenant8_home", VThingID: "energy/meter2"},
+		{TenantID: "tenant9", VSiloID: "tenant9_home", VThingID: "energy/meter1"},
+	} {
+		key, _ := vThingVSiloKey(admin, binding.VSiloID, binding.VThingID)
+		binding.OwnerMSPID = "Org2MSP"
+		data, _ := json.Marshal(binding)
+		entry := OrgIndexEntry{VSiloID: binding.VSiloID, VThingID: binding.VThingID, OwnerMSPID: "Org2MSP"}
+		if err := putOrgRecord(admin, CollectionvThingVSilos, key, entry, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// counts drifted from the silos and bindings, or never kept
+	if err := reserveVThings(admin, "tenant8", "tenant8_office", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveVThings(admin, "tenant80", "tenant80_home", 1); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("tx0")
+
+	var recounted *TenantUsageAndQuota
+	if err := stub.invoke("tx1", testTime, func() error {
+		var err error
+		recounted, err = (&SmartContract{}).RecountTenantUsage(admin, "tenant8")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := (&SmartContract{}).GetTenantUsageAndQuota(admin, "tenant8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TenantUsageAndQuota{TenantID: "tenant8", Silos: 2, VThingsPerSilo: map[string]int{"tenant8_home": 2}}
+	if !reflect.DeepEqual(recounted, want) || !reflect.DeepEqual(usage, want) {
+		t.Errorf("recounted %+v, stored %+v, want %+v", recounted, usage, want)
+	}
+	if got := siloVThings(admin, "tenant80_home"); got != 1 {
+		t.Errorf("vThings of tenant80_home = %d, want the count of another tenant kept", got)
+	}
+	if _, err := (&SmartContract{}).RecountTenantUsage(admin, "tenant_8"); errorCode(err) != CODE_INVALID_ARGUMENT {
+		t.Errorf("error = %v, want %s", err, CODE_INVALID_ARGUMENT)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	TRANSIENT_PARAMS         string = "params"
+	TRANSIENT_FLAVOUR_PARAMS string = "flavourParams"
+	TRANSIENT_SALT           string = "salt"
+	MIN_SALT_LENGTH          int    = 16
+
+	secretHashObject string = "secretHash"
+)
+
+// secretFields maps the asset kinds to their field carrying credentials, which is
+// only accepted through the transient map
+var secretFields = map[string]string{
+	NODE_THINGVISOR: TRANSIENT_PARAMS,
+	NODE_VSILO:      TRANSIENT_FLAVOUR_PARAMS,
+}
+
+func saltedHash(salt string, value string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+func secretHashKey(ctx contractapi.TransactionContextInterface, kind string, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(secretHashObject, []string{kind, id})
+	if err != nil {
+		return "", internalError(kind, id, err)
+	}
+	return key, nil
+}
+
+// readTransientSecret returns the secret field of an asset kind and its salt from
+// the transient map; found is false when the field was not passed
+func readTransientSecret(ctx contractapi.TransactionContextInterface, kind string, id string) (string, string, bool, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", "", false, internalError(kind, id, err)
+	}
+	field := secretFields[kind]
+	value, ok := transient[field]
+	if !ok {
+		return "", "", false, nil
+	}
+	salt := transient[TRANSIENT_SALT]
+	if len(salt) < MIN_SALT_LENGTH {
+		return "", "", false, invalidArgument(kind, id, TRANSIENT_SALT, "a salt of at least "+strconv.Itoa(MIN_SALT_LENGTH)+" bytes must be passed in the transient map with "+field)
+	}
+	return string(value), string(salt), true, nil
+}
+
+// takeSecret sets the secret field of an asset from the transient map and anchors
+// its salted hash in public state. Without a transient value the current one is
+// kept. The document itself may only repeat the current value.
+func takeSecret(ctx contractapi.TransactionContextInterface, kind string, id string, value *string, salt *string, currentValue string, currentSalt string) error {
+	field := secretFields[kind]
+	if (*value != "" && *value != currentValue) || (*salt != "" && *salt != currentSalt) {
+		return invalidArgument(kind, id, field, field+" must be passed in the transient map")
+	}
+	*value, *salt = currentValue, currentSalt
+	secret, secretSalt, found, err := readTransientSecret(ctx, kind, id)
+	if err != nil || !found {
+		return err
+	}
+	*value, *salt = secret, secretSalt
+	key, err := secretHashKey(ctx, kind, id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, []byte(saltedHash(secretSalt, secret))); err != nil {
+		return internalError(kind, id, err)
+	}
+	return nil
+}
+
+func deleteSecretHash(ctx contractapi.TransactionContextInterface, kind string, id string) error {
+	key, err := secretHashKey(ctx, kind, id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return internalError(kind, id, err)
+	}
+	return nil
+}
+
+// VerifySecret checks the params of a ThingVisor, or the flavourParams of a
+// VirtualSilo, passed in the transient map together with their salt against the
+// hash anchored in public state. entityType is thingvisor or virtualsilo.
+func (s *SmartContract) VerifySecret(ctx contractapi.TransactionContextInterface, entityType string, entityID string) (bool, error) {
+	if _, ok := secretFields[entityType]; !ok {
+		return false, invalidArgument(entityType, entityID, "entityType", "entityType must be one of thingvisor, virtualsilo")
+	}
+	secret, salt, found, err := readTransientSecret(ctx, entityType, entityID)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, invalidArgument(entityType, entityID, secretFields[entityType], secretFields[entityType]+" must be passed in the transient map")
+	}
+	key, err := secretHashKey(ctx, entityType, entityID)
+	if err != nil {
+		return false, err
+	}
+	hash, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, internalError(entityType, entityID, err)
+	}
+	if hash == nil {
+		return false, notFound(entityType, entityID)
+	}
+	return string(hash) == saltedHash(salt, secret), nil
+}
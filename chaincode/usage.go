@@ -0,0 +1,412 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	CollectionUsage string = "collectionUsage"
+
+	usageObject             string = "usage"
+	usageTenantObject       string = "usageTenant"
+	usageOpenObject         string = "usageOpen"
+	usageReportObject       string = "usageReport"
+	usageReportTenantObject string = "usageReportTenant"
+
+	NODE_USAGE string = "usage"
+)
+
+// UsageInterval is the time a VirtualSilo consumed a vThing, from AddVThingVSilo to
+// DeleteVThingVSilo, within the validity of the binding: Start is not before its
+// validFrom and End, empty while the binding exists, not after ValidUntil, its
+// validUntil. Messages and Bytes add up the usage reported during the interval.
+type UsageInterval struct {
+	IntervalID    string `json:"intervalID"`
+	TenantID      string `json:"tenantID"`
+	VSiloID       string `json:"vSiloID"`
+	VThingID      string `json:"vThingID"`
+	ThingVisorID  string `json:"thingVisorID"`
+	ProviderMSPID string `json:"providerMSPID"`
+	ConsumerMSPID string `json:"consumerMSPID"`
+	Owner         string `json:"owner"`
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	ValidUntil    string `json:"validUntil,omitempty"`
+	Messages      int64  `json:"messages"`
+	Bytes         int64  `json:"bytes"`
+	Reports       int    `json:"reports"`
+}
+
+// UsageReport is a usage count for a binding sent by the platform
+type UsageReport struct {
+	IntervalID    string `json:"intervalID"`
+	TenantID      string `json:"tenantID"`
+	VSiloID       string `json:"vSiloID"`
+	VThingID      string `json:"vThingID"`
+	ThingVisorID  string `json:"thingVisorID"`
+	ProviderMSPID string `json:"providerMSPID"`
+	Owner         string `json:"owner"`
+	Messages      int64  `json:"messages"`
+	Bytes         int64  `json:"bytes"`
+	ReportedBy    string `json:"reportedBy"`
+	Time          string `json:"time"`
+	TxID          string `json:"txID"`
+}
+
+// UsageSummary aggregates the usage of a tenant or ThingVisor within [From, To].
+// ActiveSeconds is the time of the intervals within the range; Messages and Bytes
+// add up the reports sent within the range.
+type UsageSummary struct {
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	Intervals     []UsageInterval `json:"intervals"`
+	ActiveSeconds int64           `json:"activeSeconds"`
+	Messages      int64           `json:"messages"`
+	Bytes         int64           `json:"bytes"`
+	Reports       int             `json:"reports"`
+}
+
+// usageIntervalKeys returns the keys an interval is stored under, by ThingVisor and by tenant
+func usageIntervalKeys(ctx contractapi.TransactionContextInterface, interval *UsageInterval) ([]string, error) {
+	thingVisorKey, err := ctx.GetStub().CreateCompositeKey(usageObject, []string{interval.ThingVisorID, interval.Start, interval.IntervalID})
+	if err != nil {
+		return nil, internalError(NODE_USAGE, interval.IntervalID, err)
+	}
+	tenantKey, err := ctx.GetStub().CreateCompositeKey(usageTenantObject, []string{interval.TenantID, interval.Start, interval.IntervalID})
+	if err != nil {
+		return nil, internalError(NODE_USAGE, interval.IntervalID, err)
+	}
+	return []string{thingVisorKey, tenantKey}, nil
+}
+
+func usageOpenKey(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(usageOpenObject, []string{VSiloID, VThingID})
+	if err != nil {
+		return "", internalError(NODE_USAGE, VSiloID+"/"+VThingID, err)
+	}
+	return key, nil
+}
+
+func putUsageInterval(ctx contractapi.TransactionContextInterface, interval *UsageInterval) error {
+	data, err := json.Marshal(interval)
+	if err != nil {
+		return internalError(NODE_USAGE, interval.IntervalID, err)
+	}
+	keys, err := usageIntervalKeys(ctx, interval)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := putPrivateAsset(ctx, CollectionUsage, key, data); err != nil {
+			return internalError(NODE_USAGE, interval.IntervalID, err)
+		}
+	}
+	return nil
+}
+
+// getOpenUsageInterval returns the open interval of a binding, or nil
+func getOpenUsageInterval(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) (*UsageInterval, error) {
+	openKey, err := usageOpenKey(ctx, VSiloID, VThingID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.GetStub().GetPrivateData(CollectionUsage, openKey)
+	if err != nil {
+		return nil, internalError(NODE_USAGE, VSiloID+"/"+VThingID, err)
+	}
+	if key == nil {
+		return nil, nil
+	}
+	data, err := ctx.GetStub().GetPrivateData(CollectionUsage, string(key))
+	if err != nil {
+		return nil, internalError(NODE_USAGE, VSiloID+"/"+VThingID, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var interval UsageInterval
+	if err := json.Unmarshal(data, &interval); err != nil {
+		return nil, internalError(NODE_USAGE, VSiloID+"/"+VThingID, err)
+	}
+	return &interval, nil
+}
+
+// boundedTime moves t into the optional RFC 3339 bounds from and until; from wins
+// when they cross. Bounds that do not parse are ignored.
+func boundedTime(t time.Time, from string, until string) time.Time {
+	if bound, err := time.Parse(time.RFC3339, until); err == nil && t.After(bound) {
+		t = bound
+	}
+	if bound, err := time.Parse(time.RFC3339, from); err == nil && t.Before(bound) {
+		t = bound
+	}
+	return t.UTC()
+}
+
+// openUsageInterval starts metering a new binding of a ThingVisor of providerMSPID,
+// from its creation or, if later, from its validFrom. The interval ID names the
+// binding as well as the transaction, which can open the intervals of several
+// bindings, e.g. in AddVThingVSiloBatch.
+func openUsageInterval(ctx contractapi.TransactionContextInterface, binding *VThingVSilo, thingVisorID string, providerMSPID string) error {
+	created, err := time.Parse(time.RFC3339, binding.CreationTime)
+	if err != nil {
+		return internalError(NODE_USAGE, binding.VSiloID+"/"+binding.VThingID, err)
+	}
+	interval := UsageInterval{
+		IntervalID:    ctx.GetStub().GetTxID() + "/" + binding.VSiloID + "/" + binding.VThingID,
+		TenantID:      binding.TenantID,
+		VSiloID:       binding.VSiloID,
+		VThingID:      binding.VThingID,
+		ThingVisorID:  thingVisorID,
+		ProviderMSPID: providerMSPID,
+		ConsumerMSPID: binding.OwnerMSPID,
+		Owner:         binding.Owner,
+		Start:         boundedTime(created, binding.ValidFrom, "").Format(time.RFC3339),
+	}
+	if binding.ValidUntil != "" {
+		validUntil, err := time.Parse(time.RFC3339, binding.ValidUntil)
+		if err != nil {
+			return internalError(NODE_USAGE, interval.IntervalID, err)
+		}
+		interval.ValidUntil = validUntil.UTC().Format(time.RFC3339)
+	}
+	if err := putUsageInterval(ctx, &interval); err != nil {
+		return err
+	}
+	keys, err := usageIntervalKeys(ctx, &interval)
+	if err != nil {
+		return err
+	}
+	openKey, err := usageOpenKey(ctx, binding.VSiloID, binding.VThingID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutPrivateData(CollectionUsage, openKey, []byte(keys[0])); err != nil {
+		return internalError(NODE_USAGE, interval.IntervalID, err)
+	}
+	return nil
+}
+
+// closeUsageInterval ends the open interval of a binding that is being deleted, at
+// the time of the transaction or, if earlier, at the end of the validity of the
+// binding. Bindings created before metering have no interval.
+func closeUsageInterval(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string) error {
+	interval, err := getOpenUsageInterval(ctx, VSiloID, VThingID)
+	if err != nil || interval == nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	interval.End = boundedTime(now, interval.Start, interval.ValidUntil).Format(time.RFC3339)
+	if err := putUsageInterval(ctx, interval); err != nil {
+		return err
+	}
+	openKey, err := usageOpenKey(ctx, VSiloID, VThingID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelPrivateData(CollectionUsage, openKey); err != nil {
+		return internalError(NODE_USAGE, interval.IntervalID, err)
+	}
+	return nil
+}
+
+// canReadUsage lets admins, the owner of the silo and the providers of the organization
+// of the ThingVisor read usage records
+func canReadUsage(ctx contractapi.TransactionContextInterface, providerMSPID string, owner string) bool {
+	if canAccessSilo(ctx, owner) {
+		return true
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	return err == nil && getRole(ctx) == ROLE_PROVIDER && (providerMSPID == "" || providerMSPID == mspID)
+}
+
+// ReportUsage records the messages and bytes a VirtualSilo received from a vThing
+// since the previous report. The binding must exist; the report is added to its
+// open usage interval.
+func (s *SmartContract) ReportUsage(ctx contractapi.TransactionContextInterface, VSiloID string, VThingID string, messages int64, bytes int64) error {
+	id := VSiloID + "/" + VThingID
+	if messages < 0 {
+		return invalidArgument(NODE_USAGE, id, "messages", "messages must not be negative")
+	}
+	if bytes < 0 {
+		return invalidArgument(NODE_USAGE, id, "bytes", "bytes must not be negative")
+	}
+	interval, err := getOpenUsageInterval(ctx, VSiloID, VThingID)
+	if err != nil {
+		return err
+	}
+	if interval == nil {
+		return invalidState(NODE_USAGE, id, "no open usage interval for vThing "+VThingID+" in VirtualSilo "+VSiloID)
+	}
+	userID, _ := ctx.GetClientIdentity().GetID()
+	userMSPID, _ := ctx.GetClientIdentity().GetMSPID()
+	if !isAdmin(ctx) && interval.ProviderMSPID != "" && interval.ProviderMSPID != userMSPID {
+		return forbidden(NODE_USAGE, id, "usage of vThing "+VThingID+" is reported by organization "+interval.ProviderMSPID)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	report := UsageReport{
+		IntervalID:    interval.IntervalID,
+		TenantID:      interval.TenantID,
+		VSiloID:       VSiloID,
+		VThingID:      VThingID,
+		ThingVisorID:  interval.ThingVisorID,
+		ProviderMSPID: interval.ProviderMSPID,
+		Owner:         interval.Owner,
+		Messages:      messages,
+		Bytes:         bytes,
+		ReportedBy:    userID,
+		Time:          now.Format(time.RFC3339),
+		TxID:          ctx.GetStub().GetTxID(),
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return internalError(NODE_USAGE, id, err)
+	}
+	timestamp := now.Format(historyTimeFormat)
+	for _, attributes := range [][]string{{usageReportObject, report.ThingVisorID}, {usageReportTenantObject, report.TenantID}} {
+		key, err := ctx.GetStub().CreateCompositeKey(attributes[0], []string{attributes[1], timestamp, report.TxID})
+		if err != nil {
+			return internalError(NODE_USAGE, id, err)
+		}
+		if err := putPrivateAsset(ctx, CollectionUsage, key, data); err != nil {
+			return internalError(NODE_USAGE, id, err)
+		}
+	}
+	interval.Messages += messages
+	interval.Bytes += bytes
+	interval.Reports++
+	if err := putUsageInterval(ctx, interval); err != nil {
+		return err
+	}
+	return SetHistory(ctx, "ReportUsage", []LogGraph{
+		{Source: userMSPID + "-provider", Target: "user-" + userID, SourceType: NODE_ORG_PROVIDER, TargetType: NODE_USER},
+		{Source: "user-" + userID, Target: userMSPID + "-provider", SourceType: NODE_USER, TargetType: NODE_ORG_PROVIDER},
+		{Source: "user-" + userID, Target: "vthing-" + VThingID, SourceType: NODE_USER, TargetType: NODE_VTHING},
+		{Source: "silo-" + VSiloID, Target: "vthing-" + VThingID, SourceType: NODE_VSILO, TargetType: NODE_VTHING},
+	}, userID, userMSPID)
+}
+
+// readUsage aggregates the intervals and reports stored under the given object types
+// for an ID, keeping only those the caller can read
+func readUsage(ctx contractapi.TransactionContextInterface, intervalObject string, reportObject string, id string, from string, to string) (*UsageSummary, error) {
+	start, end, err := parseTimeRange(NODE_USAGE, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return nil, invalidArgument(NODE_USAGE, id, "to", "to must not be before from")
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := UsageSummary{From: from, To: to, Intervals: []UsageInterval{}}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionUsage, intervalObject, []string{id})
+	if err != nil {
+		return nil, internalError(NODE_USAGE, id, err)
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_USAGE, id, err)
+		}
+		var interval UsageInterval
+		if err := json.Unmarshal(queryResponse.Value, &interval); err != nil {
+			return nil, internalError(NODE_USAGE, queryResponse.Key, err)
+		}
+		if !canReadUsage(ctx, interval.ProviderMSPID, interval.Owner) {
+			continue
+		}
+		intervalStart, err := time.Parse(time.RFC3339, interval.Start)
+		if err != nil {
+			return nil, internalError(NODE_USAGE, queryResponse.Key, err)
+		}
+		intervalEnd := boundedTime(now, "", interval.ValidUntil)
+		if interval.End != "" {
+			if intervalEnd, err = time.Parse(time.RFC3339, interval.End); err != nil {
+				return nil, internalError(NODE_USAGE, queryResponse.Key, err)
+			}
+		}
+		if !start.IsZero() && intervalStart.Before(start) {
+			intervalStart = start
+		}
+		if !end.IsZero() && intervalEnd.After(end) {
+			intervalEnd = end
+		}
+		if intervalEnd.Before(intervalStart) {
+			continue
+		}
+		summary.Intervals = append(summary.Intervals, interval)
+		summary.ActiveSeconds += int64(intervalEnd.Sub(intervalStart) / time.Second)
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_USAGE, id, err)
+	}
+
+	resultsIterator, err = ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionUsage, reportObject, []string{id})
+	if err != nil {
+		return nil, internalError(NODE_USAGE, id, err)
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, internalError(NODE_USAGE, id, err)
+		}
+		var report UsageReport
+		if err := json.Unmarshal(queryResponse.Value, &report); err != nil {
+			return nil, internalError(NODE_USAGE, queryResponse.Key, err)
+		}
+		if !canReadUsage(ctx, report.ProviderMSPID, report.Owner) {
+			continue
+		}
+		reported, err := time.Parse(time.RFC3339, report.Time)
+		if err != nil {
+			return nil, internalError(NODE_USAGE, queryResponse.Key, err)
+		}
+		if (!start.IsZero() && reported.Before(start)) || (!end.IsZero() && reported.After(end)) {
+			continue
+		}
+		summary.Messages += report.Messages
+		summary.Bytes += report.Bytes
+		summary.Reports++
+	}
+	err = resultsIterator.Close()
+	if err != nil {
+		return nil, internalError(NODE_USAGE, id, err)
+	}
+	return &summary, nil
+}
+
+// GetUsageByTenant aggregates the usage of the silos of a tenant between the optional
+// RFC 3339 bounds from and to. Consumers only see their own silos, providers the
+// vThings of their organization.
+func (s *SmartContract) GetUsageByTenant(ctx contractapi.TransactionContextInterface, tenantID string, from string, to string) (*UsageSummary, error) {
+	if tenantID == "" {
+		return nil, invalidArgument(NODE_USAGE, tenantID, "tenantID", "tenantID must not be empty")
+	}
+	return readUsage(ctx, usageTenantObject, usageReportTenantObject, tenantID, from, to)
+}
+
+// GetUsageByThingVisor aggregates the usage of the vThings of a ThingVisor between the
+// optional RFC 3339 bounds from and to, with the same visibility as GetUsageByTenant
+func (s *SmartContract) GetUsageByThingVisor(ctx contractapi.TransactionContextInterface, thingVisorID string, from string, to string) (*UsageSummary, error) {
+	if thingVisorID == "" {
+		return nil, invalidArgument(NODE_USAGE, thingVisorID, "thingVisorID", "thingVisorID must not be empty")
+	}
+	return readUsage(ctx, usageObject, usageReportObject, thingVisorID, from, to)
+}
@@ -0,0 +1,101 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// usageIntervals returns the usage intervals stored for a ThingVisor
+func usageIntervals(t *testing.T, ctx contractapi.TransactionContextInterface, thingVisorID string) []UsageInterval {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(CollectionUsage, usageObject, []string{thingVisorID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var intervals []UsageInterval
+	for resultsIterator.HasNext() {
+		queryResponse, _ := resultsIterator.Next()
+		var interval UsageInterval
+		if err := json.Unmarshal(queryResponse.Value, &interval); err != nil {
+			t.Fatal(err)
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals
+}
+
+func TestUsageIntervalWithinValidity(t *testing.T) {
+	jst := time.FixedZone("JST", 9*3600)
+	tests := []struct {
+		name       string
+		validFrom  string
+		validUntil string
+		deleted    int64
+		start      int64
+		end        int64
+	}{
+		{name: "unbounded", deleted: 600, start: 0, end: 600},
+		{name: "starts later", validFrom: rfc3339(testTime + 300), deleted: 600, start: 300, end: 600},
+		{name: "validFrom in another zone", validFrom: time.Unix(testTime+300, 0).In(jst).Format(time.RFC3339), deleted: 600, start: 300, end: 600},
+		{name: "swept after validUntil", validUntil: rfc3339(testTime + 300), deleted: 900, start: 0, end: 300},
+		{name: "deleted before validFrom", validFrom: rfc3339(testTime + 300), validUntil: rfc3339(testTime + 600), deleted: 100, start: 300, end: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTestStub()
+			ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+			putTestVThings(t, ctx, "weather", "Org1MSP", "Tokyo")
+			putTestSilo(t, ctx, "tenant1_a", "Org1MSP")
+			stub.MockTransactionEnd("tx0")
+			contract := &SmartContract{}
+			if err := stub.invoke("tx1", testTime, func() error {
+				return contract.AddVThingVSilo(ctx, "tenant1_a", "weather/Tokyo", tt.validFrom, tt.validUntil, "")
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := stub.invoke("tx2", testTime+tt.deleted, func() error {
+				return contract.DeleteVThingVSilo(ctx, "tenant1_a", "weather/Tokyo")
+			}); err != nil {
+				t.Fatal(err)
+			}
+			intervals := usageIntervals(t, ctx, "weather")
+			if len(intervals) != 1 {
+				t.Fatalf("intervals = %+v", intervals)
+			}
+			if intervals[0].Start != rfc3339(testTime+tt.start) || intervals[0].End != rfc3339(testTime+tt.end) {
+				t.Errorf("interval = [%s, %s], want [%s, %s]", intervals[0].Start, intervals[0].End, rfc3339(testTime+tt.start), rfc3339(testTime+tt.end))
+			}
+		})
+	}
+}
+
+func TestOpenUsageIntervalEndsWithValidity(t *testing.T) {
+	stub := newTestStub()
+	ctx := newTestContext(stub, "tenant1", "Org1MSP", ROLE_CONSUMER)
+	putTestVThings(t, ctx, "weather", "Org1MSP", "Tokyo")
+	putTestSilo(t, ctx, "tenant1_a", "Org1MSP")
+	stub.MockTransactionEnd("tx0")
+	contract := &SmartContract{}
+	if err := stub.invoke("tx1", testTime, func() error {
+		return contract.AddVThingVSilo(ctx, "tenant1_a", "weather/Tokyo", rfc3339(testTime+60), rfc3339(testTime+300), "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var summary *UsageSummary
+	if err := stub.invoke("tx2", testTime+900, func() error {
+		var err error
+		summary, err = contract.GetUsageByTenant(ctx, "tenant1", "", "")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Intervals) != 1 || summary.ActiveSeconds != 240 {
+		t.Errorf("summary = %+v, want 240 active seconds", summary)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// decodeStrict unmarshals a JSON document received as a transaction argument,
+// rejecting unknown fields and trailing data
+func decodeStrict(kind string, id string, data string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return invalidArgument(kind, id, "", "malformed "+kind+" document: "+err.Error())
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return invalidArgument(kind, id, "", "malformed "+kind+" document: unexpected data after the document")
+	}
+	return nil
+}
+
+// parseVThingID splits a vThing ID of the form <thingVisorID>/<name>
+func parseVThingID(vThingID string) (string, string, error) {
+	keyArr := strings.Split(vThingID, "/")
+	if len(keyArr) != 2 || keyArr[0] == "" || keyArr[1] == "" {
+		return "", "", invalidArgument(NODE_VTHING, vThingID, "id", "vThing ID must be of the form <thingVisorID>/<name>")
+	}
+	return keyArr[0], keyArr[1], nil
+}
+
+// parseVSiloID splits a VirtualSilo ID of the form <tenantID>_<vSiloName>
+func parseVSiloID(VSiloID string) (string, string, error) {
+	keyArr := strings.Split(VSiloID, "_")
+	if len(keyArr) != 2 || keyArr[0] == "" || keyArr[1] == "" {
+		return "", "", invalidArgument(NODE_VSILO, VSiloID, "vSiloID", "VirtualSilo ID must be of the form <tenantID>_<vSiloName>")
+	}
+	return keyArr[0], keyArr[1], nil
+}
+
+func validateThingVisor(id string, thingVisor *ThingVisor) error {
+	if id == "" || strings.Contains(id, "/") {
+		return invalidArgument(NODE_THINGVISOR, id, "thingVisorID", "ThingVisor ID must be non-empty and must not contain '/'")
+	}
+	if thingVisor.ThingVisorID != id {
+		return invalidArgument(NODE_THINGVISOR, id, "thingVisorID", "thingVisorID '"+thingVisor.ThingVisorID+"' does not match '"+id+"'")
+	}
+	if len(thingVisor.VThings) > 0 {
+		return invalidArgument(NODE_THINGVISOR, id, "vThings", "vThings are managed with AddVThingToThingVisor")
+	}
+	return nil
+}
+
+func validateVThing(thingVisorID string, vThing *VThingTV) error {
+	tvID, _, err := parseVThingID(vThing.ID)
+	if err != nil {
+		return err
+	}
+	if tvID != thingVisorID {
+		return invalidArgument(NODE_VTHING, vThing.ID, "id", "vThing does not belong to ThingVisor '"+thingVisorID+"'")
+	}
+	return validatePolicy(vThing.ID, vThing.Policy)
+}
+
+func validateFlavour(id string, flavour *Flavour) error {
+	if flavour.FlavourID == "" || flavour.FlavourID != id {
+		return invalidArgument(NODE_FLAVOUR, id, "flavourID", "flavourID '"+flavour.FlavourID+"' does not match '"+id+"'")
+	}
+	return nil
+}
+
+func validateVirtualSilo(id string, silo *VirtualSilo) error {
+	tenantID, _, err := parseVSiloID(id)
+	if err != nil {
+		return err
+	}
+	if silo.VSiloID != id {
+		return invalidArgument(NODE_VSILO, id, "vSiloID", "vSiloID '"+silo.VSiloID+"' does not match '"+id+"'")
+	}
+	if silo.TenantID != tenantID {
+		return invalidArgument(NODE_VSILO, id, "tenantID", "tenantID '"+silo.TenantID+"' does not match the tenant of '"+id+"'")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// txTime returns the timestamp of the transaction in RFC 3339
+func txTime(ctx contractapi.TransactionContextInterface) (string, error) {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	return now.Format(time.RFC3339), nil
+}
+
+// checkVersion rejects an update prepared against another version of the asset.
+// An expectedVersion of 0 skips the check.
+func checkVersion(kind string, id string, current int, expectedVersion int) error {
+	if expectedVersion < 0 {
+		return invalidArgument(kind, id, "expectedVersion", "expectedVersion must not be negative")
+	}
+	if expectedVersion != 0 && expectedVersion != current {
+		return conflict(kind, id, expectedVersion, current)
+	}
+	return nil
+}
+
+// setLastModified stamps an asset with the time of the transaction and the caller
+func setLastModified(ctx contractapi.TransactionContextInterface, lastModified *string, lastModifiedBy *string) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	userID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return internalError("", "", err)
+	}
+	*lastModified = now
+	*lastModifiedBy = userID
+	return nil
+}
@@ -327,3 +327,23 @@ export const mqttControlBrokerPort = env
   .asString();
 
 
+
+/**
+ * The interval in seconds at which expired vThing bindings are swept, 0 to disable
+ * the sweep
+ */
+export const bindingSweepInterval = env
+  .get('BINDING_SWEEP_INTERVAL')
+  .default('60')
+  .example('60')
+  .asIntPositive();
+
+/**
+ * The number of expired bindings removed by each SweepExpiredBindings transaction,
+ * at most 200
+ */
+export const bindingSweepBatchSize = env
+  .get('BINDING_SWEEP_BATCH_SIZE')
+  .default('50')
+  .example('50')
+  .asIntPositive();
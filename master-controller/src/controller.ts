@@ -36,7 +36,7 @@ import {
 } from "./thingvisor";
 import {getDeployZoneOnKubernetes} from "./k8s";
 import {defaultDeployZone} from "./config";
-import {getUserByID, User} from "./user";
+import {chaincodeRole, getUserByID, User} from "./user";
 import mongoose from "mongoose";
 import * as config from "./config";
 import {buildCAClient, getContract} from "./fabric";
@@ -112,6 +112,8 @@ controller.post(
           enrollmentSecret: req.body.password,
           attrs: [
             {name:"hf.Revoker", value:"true"},
+            // checked by the chaincode, see functionPolicies and checkSiloAccess
+            {name:"viriot.role", value:chaincodeRole(req.body.role), ecert:true},
           ]
         }, adminUser);
         logger.debug('Enrolling User');
@@ -530,6 +532,7 @@ export interface VThingTV{
   id: string
   description: string
   endpoint: string
+  version: number
 }
 
 export interface VThingTVWithKey{
@@ -574,7 +577,7 @@ controller.post('/deleteThingVisor',
           }) : [];
           logger.debug("VTHINGIDs:")
           console.log(vThingIDs)
-          await contract.submitTransaction("DeleteThingVisor", tvId, ...vThingIDs);
+          await contract.submitTransaction("DeleteThingVisor", tvId, "true");
           if(!thingVisor.debug_mode){
             await deleteThingVisorOnKubernetes(thingVisor);
           }
@@ -589,7 +592,7 @@ controller.post('/deleteThingVisor',
         mqttClient.publish(`${thingVisorPrefix}/${tvId}/${inControlSuffix}`, JSON.stringify(destroyCmd).replace("\'", "\""));
 
         if(thingVisor.debug_mode){
-          await contract.submitTransaction("DeleteThingVisor", tvId);
+          await contract.submitTransaction("DeleteThingVisor", tvId, "false");
         }
         return res.status(OK).json({
           result:  `thingVisor: ${tvId} deleting (force=false)`,
@@ -921,16 +924,14 @@ controller.post('/siloDestroy',
         const vSilo = JSON.parse((await contract.evaluateTransaction('GetVirtualSilo', vSiloID)).toString());
         if(req.body.force){
           await deleteVirtualSiloOnKubernetes(vSilo);
-          const vThingsData = await contract.evaluateTransaction('GetVThingVSilosByVSiloID', vSiloID);
-          const vThings : VThingVSilo[] = (vThingsData.length > 0) ? JSON.parse(vThingsData.toString()) : [];
-          const vThingIDs = vThings.map((element) => element.vThingID);
-          await contract.submitTransaction("DeleteVirtualSilo", vSiloID, ...vThingIDs)
+          await contract.submitTransaction("DeleteVirtualSilo", vSiloID)
           mqttCallBack.delete(`${vSiloPrefix}/${vSiloID}/${outControlSuffix}`);
           mqttClient.unsubscribe(`${vSiloPrefix}/${vSiloID}/${outControlSuffix}`);
           return res.status(OK).json({
             message: `Virtual Silo ${vSiloID} destroyed (force=true)`
           });
         }
+        await contract.submitTransaction('StopVirtualSilo', vSiloID);
         const destroyCmd = {command: "destroyVSilo", vSiloID: vSiloID};
         mqttClient.publish(`${vSiloPrefix}/${vSiloID}/${inControlSuffix}`, JSON.stringify(destroyCmd).replace("\'", "\""));
         return res.status(OK).json({
@@ -987,6 +988,8 @@ export interface VThingVSilo{
   vSiloID: string
   creationTime: string
   vThingID: string
+  validFrom?: string
+  validUntil?: string
 }
 
 controller.post('/addVThing',
@@ -1031,14 +1034,8 @@ controller.post('/addVThing',
           vThingID: vThingID,
           vThingType: vThingType,
         }
+        await contract.submitTransaction("AddVThingVSilo", vSiloID, vThingID, req.body.validFrom ?? "", req.body.validUntil ?? "", req.body.purpose ?? "");
         mqttClient.publish(`${vSiloPrefix}/${vSiloID}/${inControlSuffix}`, JSON.stringify(mqttMessage).replace("\'", "\""));
-        const vThingVSiloEntry = {
-          tenantID: tenantID,
-          vThingID: vThingID,
-          creationTime: new Date().toISOString(),
-          vSiloID: vSiloID
-        }
-        await contract.submitTransaction("AddVThingVSilo", vSiloID, vThingID, JSON.stringify(vThingVSiloEntry));
         return res.status(OK).json({"message": 'vThing created'});
       } catch (err) {
         const error = err as FabricError
@@ -1122,7 +1119,7 @@ controller.post('/setVThingEndpoint',
           endpoint: endpoint,
         }
         mqttClient.publish(`${thingVisorPrefix}/${tvId}/${inControlSuffix}`, JSON.stringify(mqttMessage).replace("\'", "\""));
-        await contract.submitTransaction("UpdateVThingOfThingVisor", vThingID, JSON.stringify(vThing));
+        await contract.submitTransaction("UpdateVThingOfThingVisor", vThingID, JSON.stringify(vThing), String(vThing.version));
         return res.status(OK).json({"message": "vThing endpoint created"});
       } catch (err) {
         const error = err as FabricError
@@ -1158,7 +1155,7 @@ controller.post('/delVThingEndpoint',
           vThingID: vThingID
         }
         mqttClient.publish(`${thingVisorPrefix}/${tvId}/${inControlSuffix}`, JSON.stringify(mqttMessage).replace("\'", "\""));
-        await contract.submitTransaction("UpdateVThingOfThingVisor", vThingID, JSON.stringify(vThing));
+        await contract.submitTransaction("UpdateVThingOfThingVisor", vThingID, JSON.stringify(vThing), String(vThing.version));
         return res.status(OK).json({"message": "vThing endpoint deleted"});
       } catch (err) {
         const error = err as FabricError
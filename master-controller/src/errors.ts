@@ -64,6 +64,50 @@ export class AssetNotFoundError extends ContractError {
     }
 }
 
+/**
+ * Represents a structured error returned by the viriot chaincode. The code is
+ * one of NOT_FOUND, ALREADY_EXISTS, INVALID_STATE, FORBIDDEN, INVALID_ARGUMENT,
+ * CONFLICT or INTERNAL; kind and id identify the asset concerned.
+ */
+export class ChaincodeError extends ContractError {
+    code: string;
+    kind?: string;
+    id?: string;
+    field?: string;
+
+    constructor(
+        code: string,
+        message: string,
+        transactionId: string,
+        kind?: string,
+        id?: string,
+        field?: string
+    ) {
+        super(message, transactionId);
+        Object.setPrototypeOf(this, ChaincodeError.prototype);
+
+        this.name = 'ChaincodeError';
+        this.code = code;
+        this.kind = kind;
+        this.id = id;
+        this.field = field;
+    }
+}
+
+/**
+ * Represents the error which occurs when an update names an expected version
+ * that is no longer the current version of the asset. The asset should be read
+ * again before retrying the update.
+ */
+export class AssetVersionConflictError extends ContractError {
+    constructor(message: string, transactionId: string) {
+        super(message, transactionId);
+        Object.setPrototypeOf(this, AssetVersionConflictError.prototype);
+
+        this.name = 'AssetVersionConflictError';
+    }
+}
+
 /**
  * Enumeration of possible retry actions.
  */
@@ -153,6 +197,40 @@ export const isDuplicateTransactionError = (err: unknown): boolean => {
     return isDuplicate === true;
 };
 
+interface ChaincodeErrorPayload {
+    code: string;
+    kind?: string;
+    id?: string;
+    field?: string;
+    message: string;
+}
+
+/**
+ * Extracts the JSON error document written by the viriot chaincode from the
+ * error message of the peer, e.g.
+ *   - '... message={"code":"NOT_FOUND","kind":"thingvisor","id":"tv1","message":"..."}'
+ */
+const matchChaincodeErrorPayload = (
+    message: string
+): ChaincodeErrorPayload | null => {
+    const chaincodeErrorRegex =
+        /\{"code":"[A-Z_]+"(?:,"(?:kind|id|field)":"(?:[^"\\]|\\.)*")*,"message":"(?:[^"\\]|\\.)*"\}/;
+    const chaincodeErrorMatch = message.match(chaincodeErrorRegex);
+    logger.debug(
+        { message: message, result: chaincodeErrorMatch },
+        'Checking for chaincode error document'
+    );
+
+    if (chaincodeErrorMatch === null) {
+        return null;
+    }
+    try {
+        return JSON.parse(chaincodeErrorMatch[0]) as ChaincodeErrorPayload;
+    } catch (err) {
+        return null;
+    }
+};
+
 /**
  * Matches asset already exists error strings from the asset contract
  *
@@ -242,6 +320,27 @@ export const handleError = (
     logger.debug({ transactionId: transactionId, err }, 'Processing error');
 
     if (isErrorLike(err)) {
+        const chaincodeError = matchChaincodeErrorPayload(err.message);
+        if (chaincodeError !== null) {
+            switch (chaincodeError.code) {
+            case 'ALREADY_EXISTS':
+                return new AssetExistsError(chaincodeError.message, transactionId);
+            case 'NOT_FOUND':
+                return new AssetNotFoundError(chaincodeError.message, transactionId);
+            case 'CONFLICT':
+                return new AssetVersionConflictError(chaincodeError.message, transactionId);
+            default:
+                return new ChaincodeError(
+                    chaincodeError.code,
+                    chaincodeError.message,
+                    transactionId,
+                    chaincodeError.kind,
+                    chaincodeError.id,
+                    chaincodeError.field
+                );
+            }
+        }
+
         const assetAlreadyExistsMatch = matchAssetAlreadyExistsMessage(err.message);
         if (assetAlreadyExistsMatch !== null) {
             return new AssetExistsError(assetAlreadyExistsMatch, transactionId);
@@ -15,10 +15,11 @@ import {
 } from 'fabric-network';
 import * as config from './config';
 import { logger } from './logger';
-import { handleError } from './errors';
+import { AssetVersionConflictError, handleError } from './errors';
 import * as protos from 'fabric-protos';
 import FabricCAServices from "fabric-ca-client";
 import {wallet} from "./index";
+import { randomBytes } from 'crypto';
 
 /**
  * Creates an in memory wallet to hold credentials for an Org1 and Org2 user
@@ -141,6 +142,56 @@ export const evatuateTransaction = async (
 };
 
 
+/**
+ * Submit a transaction whose sensitive arguments, such as ThingVisor params, are
+ * passed in the transient map with a random salt, so that they are not written to
+ * the block
+ */
+export const submitTransientTransaction = async (
+  contract: Contract,
+  transactionName: string,
+  transientArgs: Record<string, string>,
+  ...transactionArgs: string[]
+): Promise<Buffer> => {
+  const transientData: Record<string, Buffer> = {};
+  for (const [name, value] of Object.entries(transientArgs)) {
+    transientData[name] = Buffer.from(value);
+  }
+  if (Object.keys(transientData).length > 0) {
+    transientData.salt = randomBytes(32);
+  }
+  return contract.createTransaction(transactionName).setTransient(transientData).submit(...transactionArgs);
+};
+
+/**
+ * Submit an Update transaction with the version of the asset read by its getter, so
+ * that the chaincode refuses it when another transaction changed the asset first.
+ * The asset is read again and the update retried on such a conflict; the conflict
+ * is thrown once the retries are exhausted.
+ */
+export const submitVersionedUpdate = async (
+  contract: Contract,
+  getterName: string,
+  updateName: string,
+  id: string,
+  entry: object,
+  transientArgs: Record<string, string> = {},
+  retries = 3
+): Promise<Buffer> => {
+  for (let attempt = 0; ; attempt++) {
+    const current = JSON.parse((await contract.evaluateTransaction(getterName, id)).toString());
+    try {
+      return await submitTransientTransaction(contract, updateName, transientArgs, id, JSON.stringify(entry), String(current.version));
+    } catch (err) {
+      const error = handleError("", err);
+      if (!(error instanceof AssetVersionConflictError) || attempt >= retries) {
+        throw error;
+      }
+      logger.debug({ id, attempt }, `${updateName} conflicts with a concurrent change, retrying`);
+    }
+  }
+};
+
 /**
  * Get the validation code of the specified transaction
  */
@@ -1,6 +1,6 @@
 import * as k8s from "@kubernetes/client-node";
-import {STATUS_ERROR, STATUS_READY, VThingTVWithKey} from "./controller";
-import {getContract} from "./fabric";
+import {STATUS_PENDING, VThingTVWithKey} from "./controller";
+import {getContract, submitVersionedUpdate} from "./fabric";
 import {logger} from "./logger";
 import {deleteThingVisorOnKubernetes, outControlSuffix, thingVisorPrefix} from "./thingvisor";
 import {mqttClient} from "./index";
@@ -29,24 +29,19 @@ export const createFlavourOnKubernetes = async (
         }
         logger.debug("Creation of Flavour on k8s");
         const newFlavourEntry = {
+            flavourID: flavourID,
             flavourParams: flavourParams,
             imageName: imageList,
             flavourDescription: flavourDescription,
             creationTime: creationTime,
-            status: STATUS_READY,
+            status: STATUS_PENDING,
             yamlFiles: yamlList}
-        await contract.submitTransaction("UpdateFlavour", flavourID, JSON.stringify(newFlavourEntry));
+        await submitVersionedUpdate(contract, "GetFlavour", "UpdateFlavour", flavourID, newFlavourEntry);
+        await contract.submitTransaction("MarkFlavourReady", flavourID);
     }catch (e) {
         logger.debug({e},"Error to save Flavour!");
         try{
-            const newFlavourEntry = {
-                flavourParams: flavourParams,
-                imageName: imageList,
-                flavourDescription: flavourDescription,
-                creationTime: creationTime,
-                status: STATUS_ERROR,
-                yamlFiles: yamlList}
-            await contract.submitTransaction("UpdateFlavour", flavourID, JSON.stringify(newFlavourEntry));
+            await contract.submitTransaction("FailFlavour", flavourID, (e as Error).message);
         }catch (ex){
             logger.debug({ex},"Error to save Flavour for error case!");
         }
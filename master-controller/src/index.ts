@@ -21,6 +21,7 @@ import { mqttCallBack } from "./mqttcallback";
 import { controller } from "./controller";
 import { Queue, QueueScheduler, Worker } from "bullmq";
 import { initJobQueue, initJobQueueScheduler, initJobQueueWorker } from "./jobs";
+import { startBindingSweep } from "./sweep";
 
 
 
@@ -102,6 +103,8 @@ async function main() {
     logger.info(err);
   });
   app.locals["mqtt"] = mqttClient;
+  logger.info('Starting sweep of expired bindings');
+  await startBindingSweep();
   logger.info('Starting REST server');
   app.listen(config.port, () => {
     logger.info('REST server started on port: %d', config.port);
@@ -1,7 +1,7 @@
 import { logger } from "./logger";
 import {getContract} from "./fabric";
 import { mqttClient} from "./index";
-import {VThingTV, VThingTVWithKey, VThingVSilo} from "./controller";
+import {VThingTVWithKey, VThingVSilo} from "./controller";
 import {
   deleteThingVisorOnKubernetes,
   inControlSuffix,
@@ -71,7 +71,15 @@ export const onMessageCreateVThing = async(res: any) => {
       }
       await new Promise(f => setTimeout(f, 100));
     }
-    await contract.submitTransaction("AddVThingToThingVisor", res.thingVisorID, JSON.stringify(res.vThing));
+    if (Array.isArray(res.vThings)) {
+      const addition = JSON.parse((await contract.submitTransaction("AddVThingsToThingVisor", res.thingVisorID, JSON.stringify(res.vThings))).toString());
+      if (addition.replaced.length > 0) {
+        // the silos bound to a replaced vThing keep their bindings and subscriptions
+        logger.info({ thingVisorID: tvID, replaced: addition.replaced }, "ThingVisor replaced existing vThings");
+      }
+    } else {
+      await contract.submitTransaction("AddVThingToThingVisor", res.thingVisorID, JSON.stringify(res.vThing));
+    }
   }catch (e){
     logger.error(
         { e },
@@ -86,9 +94,9 @@ export const onMessageDestroyThingVisorAck = async(res: any) => {
     const contract = await getContract(res.ownerID);
     const data = await contract.evaluateTransaction('GetThingVisor', tvID);
     const thingVisor = JSON.parse(data.toString());
-    const vThings: VThingTV[] = thingVisor.vThings;
-    const vThingIDs = vThings.map(element => element.id);
-    await contract.submitTransaction("DeleteThingVisor", tvID, ...vThingIDs);
+    // the ThingVisor is already gone, so detach whatever is still bound to its vThings;
+    // bindings of other organizations make the chaincode refuse until they are deleted
+    await contract.submitTransaction("DeleteThingVisor", tvID, "true");
     if(!thingVisor.debug_mode){
       await deleteThingVisorOnKubernetes(thingVisor);
     }
@@ -108,10 +116,7 @@ export const onMessageDestroyVirtualSiloAck = async(res: any) => {
     const contract = await getContract(res.ownerID);
     const vSilo = JSON.parse((await contract.evaluateTransaction('GetVirtualSilo', vSiloID)).toString());
     await deleteVirtualSiloOnKubernetes(vSilo);
-    const vThingsData = await contract.evaluateTransaction('GetVThingVSilosByVSiloID', vSiloID);
-    const vThings : VThingVSilo[] = (vThingsData.length > 0) ? JSON.parse(vThingsData.toString()) : [];
-    const vThingIDs = vThings.map((element) => element.vThingID);
-    await contract.submitTransaction("DeleteVirtualSilo", vSiloID, ...vThingIDs)
+    await contract.submitTransaction("DeleteVirtualSilo", vSiloID)
     mqttCallBack.delete(`${vSiloPrefix}/${vSiloID}/${outControlSuffix}`);
     mqttClient.unsubscribe(`${vSiloPrefix}/${vSiloID}/${outControlSuffix}`);
   }catch (e){
@@ -6,8 +6,8 @@ import {
     mqttDataBrokerHost,
     mqttDataBrokerPort, workingNamespace
 } from "./config";
-import {STATUS_PENDING, STATUS_RUNNING} from "./controller";
-import {getContract} from "./fabric";
+import {STATUS_PENDING} from "./controller";
+import {getContract, submitVersionedUpdate} from "./fabric";
 import {logger} from "./logger";
 import {
     mqttCallBack,
@@ -49,7 +49,7 @@ export const createVirtualSiloOnKubernetes = async (
         creationTime: new Date().toISOString(),
         tenantID: tenantID,
         status: STATUS_PENDING,
-        flavourParams: flavourParams,
+        flavourID: flavourID,
         MQTTDataBroker: mqttDataBroker,
         MQTTControlBroker: mqttControlBroker,
         vSiloID: vSiloID,
@@ -57,7 +57,7 @@ export const createVirtualSiloOnKubernetes = async (
         additionalServicesNames: [],
         additionalDeploymentsNames: []
     }
-    await contract.submitTransaction("UpdateVirtualSilo", vSiloID, JSON.stringify(siloEntry));
+    await submitVersionedUpdate(contract, "GetVirtualSilo", "UpdateVirtualSilo", vSiloID, siloEntry, {flavourParams: flavourParams});
     logger.debug("Creating VirtualSilo On K8s");
     const topic = `${vSiloPrefix}/${vSiloID}/${outControlSuffix}`;
     mqttCallBack.set(topic, onVSiloOutControlMessage);
@@ -144,8 +144,8 @@ export const createVirtualSiloOnKubernetes = async (
         const newvSiloEntry = {
             creationTime: new Date().toISOString(),
             tenantID: tenantID,
-            flavourParams: flavourParams,
-            status: STATUS_RUNNING,
+            flavourID: flavourID,
+            status: STATUS_PENDING,
             ipAddress: ipAddress,
             deploymentName: deploymentName,
             serviceName: serviceName,
@@ -158,9 +158,15 @@ export const createVirtualSiloOnKubernetes = async (
             additionalServicesNames: deploymentsNamesList.filter(name => name != deploymentName),
             additionalDeploymentsNames: servicesNamesList.filter(name => name != serviceName),
         }
-        await contract.submitTransaction("UpdateVirtualSilo", vSiloID, JSON.stringify(newvSiloEntry));
+        await submitVersionedUpdate(contract, "GetVirtualSilo", "UpdateVirtualSilo", vSiloID, newvSiloEntry);
+        await contract.submitTransaction("RunVirtualSilo", vSiloID);
     }catch (e) {
         logger.debug({e},"Error to Create ThingVisor!");
+        try{
+            await contract.submitTransaction("FailVirtualSilo", vSiloID, (e as Error).message);
+        }catch (ex){
+            logger.debug({ex},"Error to save VirtualSilo for error case!");
+        }
     }
 }
 
@@ -0,0 +1,97 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Removes the vThing bindings whose validUntil has passed and tears down the
+ * subscriptions of their silos
+ */
+
+import { Contract, ContractEvent } from "fabric-network";
+import * as config from "./config";
+import { getContract } from "./fabric";
+import { mqttClient } from "./index";
+import { logger } from "./logger";
+import { inControlSuffix, vSiloPrefix } from "./thingvisor";
+import { getUserByID } from "./user";
+
+const SWEEP_EVENT = "SweepExpiredBindings";
+
+interface LogGraph {
+  source: string;
+  source_type: string;
+  target: string;
+  target_type: string;
+}
+
+/**
+ * Publish a deleteVThing command to the silo of every binding removed by a sweep.
+ * The History payload of the event has one "silo-<vSiloID>" -> "vthing-<vThingID>"
+ * edge of source_type "deleted" per binding; sweeps of other organizations are
+ * ignored, since their silos are not controlled by this master-controller.
+ */
+const onSweepEvent = async (event: ContractEvent): Promise<void> => {
+  if (event.eventName !== SWEEP_EVENT || !event.payload) {
+    return;
+  }
+  const history = JSON.parse(event.payload.toString());
+  if (history.user_mspid !== config.mspIdOrg) {
+    return;
+  }
+  for (const edge of (history.graph_data || []) as LogGraph[]) {
+    if (edge.source_type !== "deleted" || !edge.source.startsWith("silo-") || !edge.target.startsWith("vthing-")) {
+      continue;
+    }
+    const vSiloID = edge.source.substring("silo-".length);
+    const vThingID = edge.target.substring("vthing-".length);
+    const mqttMessage = {
+      command: "deleteVThing",
+      vSiloID: vSiloID,
+      vThingID: vThingID,
+    };
+    logger.debug({ vSiloID, vThingID }, "Tearing down an expired binding");
+    mqttClient.publish(`${vSiloPrefix}/${vSiloID}/${inControlSuffix}`, JSON.stringify(mqttMessage));
+  }
+};
+
+/**
+ * Submit SweepExpiredBindings until no expired binding is left
+ */
+const sweepExpiredBindings = async (contract: Contract): Promise<void> => {
+  for (;;) {
+    const sweep = JSON.parse((await contract.submitTransaction(SWEEP_EVENT, String(config.bindingSweepBatchSize))).toString());
+    if (sweep.bindings.length > 0) {
+      logger.info("Swept %d expired bindings", sweep.bindings.length);
+    }
+    if (!sweep.more) {
+      return;
+    }
+  }
+};
+
+/**
+ * Listen for the events of SweepExpiredBindings and submit the transaction every
+ * bindingSweepInterval seconds as the organization admin
+ */
+export const startBindingSweep = async (): Promise<void> => {
+  if (config.bindingSweepInterval === 0) {
+    logger.info("Sweep of expired bindings is disabled");
+    return;
+  }
+  let contract: Contract | undefined;
+  const sweep = async () => {
+    try {
+      if (!contract) {
+        // the admin is created in the background, so its identity may not be in the wallet yet
+        if (!(await getUserByID(config.orgAdminUser))) {
+          logger.debug("Admin does not exist yet, skipping the sweep of expired bindings");
+          return;
+        }
+        contract = await getContract(config.orgAdminUser);
+        await contract.addContractListener(onSweepEvent);
+      }
+      await sweepExpiredBindings(contract);
+    } catch (err) {
+      logger.error({ err }, "Error sweeping expired bindings");
+    }
+  };
+  setInterval(sweep, config.bindingSweepInterval * 1000);
+};
@@ -1,4 +1,4 @@
-import {controller, STATUS_PENDING, STATUS_RUNNING} from "./controller";
+import {controller, STATUS_PENDING} from "./controller";
 import { body, validationResult } from "express-validator";
 import { Request, Response } from "express";
 import { Wallet } from "fabric-network";
@@ -24,7 +24,7 @@ import {
   ServiceInstance
 } from "./k8s";
 import { mqttClient, kc } from "./index";
-import {getContract} from "./fabric";
+import {getContract, submitTransientTransaction, submitVersionedUpdate} from "./fabric";
 
 const { BAD_REQUEST, INTERNAL_SERVER_ERROR, NOT_FOUND, OK, UNAUTHORIZED } = StatusCodes;
 
@@ -59,15 +59,13 @@ export const createThingVisorOnKubernetes = async (
     status: STATUS_PENDING,
     debug_mode: debugMode,
     vThings: [],
-    params: thingVisorParams,
     MQTTDataBroker: mqttDataBroker,
     MQTTControlBroker: mqttControlBroker,
-    yamlFiles: yamlFiles,
     additionalServicesNames: [],
     additionalDeploymentsNames: [],
   }
   const contract =  await getContract(userID);
-  await contract.submitTransaction("UpdateThingVisor", thingVisorID, JSON.stringify(thingVisorEntry));
+  await submitVersionedUpdate(contract, "GetThingVisor", "UpdateThingVisor", thingVisorID, thingVisorEntry, {params: thingVisorParams});
   logger.debug("Creating Thing Visor On K8s");
   const topic = `${thingVisorPrefix}/${thingVisorID}/${outControlSuffix}`;
   logger.debug("Subsribed Topic:"+topic);
@@ -160,7 +158,7 @@ export const createThingVisorOnKubernetes = async (
       creationTime: creationTime,
       tvDescription: thingVisorDescription,
       //"imageName": tv_img_name,
-      status: STATUS_RUNNING,
+      status: STATUS_PENDING,
       debug_mode: debugMode,
       containerID: containerID,
       ipAddress: ipAddress,
@@ -169,16 +167,20 @@ export const createThingVisorOnKubernetes = async (
       //"port": exposed_ports,
       //"IP": gateway_IP,
       vThings: [],
-      params: thingVisorParams,
       MQTTDataBroker: mqttDataBroker,
       MQTTControlBroker: mqttControlBroker,
-      yamlFiles: yamlFiles,
       additionalServicesNames: deploymentsNamesList.filter(name => name != deploymentName),
       additionalDeploymentsNames: servicesNamesList.filter(name => name != serviceName),
     }
-    await contract.submitTransaction("UpdateThingVisor", thingVisorID, JSON.stringify(newThingVisorEntry));
+    await submitVersionedUpdate(contract, "GetThingVisor", "UpdateThingVisor", thingVisorID, newThingVisorEntry);
+    await contract.submitTransaction("RunThingVisor", thingVisorID);
   }catch (e) {
     logger.debug({e},"Error to Create ThingVisor!");
+    try{
+      await contract.submitTransaction("FailThingVisor", thingVisorID, (e as Error).message);
+    }catch (ex){
+      logger.debug({ex},"Error to save ThingVisor for error case!");
+    }
   }
 }
 
@@ -202,7 +204,9 @@ export const deleteThingVisorOnKubernetes = async (tvEntry : any) => {
 export const updateThingVisor = async (userID: string, thingVisorID: string, tvDescription: string, params: string) => {
   try{
     const contract =  await getContract(userID);
-    await contract.submitTransaction("UpdateThingVisorPartial", thingVisorID, tvDescription, params);
+    const thingVisor = JSON.parse((await contract.evaluateTransaction("GetThingVisor", thingVisorID)).toString());
+    const transientArgs: Record<string, string> = params ? {params: params} : {};
+    await submitTransientTransaction(contract, "UpdateThingVisorPartial", transientArgs, thingVisorID, tvDescription, String(thingVisor.version));
   }catch (e){
     logger.debug(`Update Thing Visor ${thingVisorID} Failed!`);
   }
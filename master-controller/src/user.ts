@@ -31,6 +31,21 @@ export async function createAdmin(){
     if (docs.length == 0){
       try {
         const caClient = buildCAClient(config.connectionProfileOrg, config.CA);
+        const bootstrapEnrollment = await caClient.enroll({ enrollmentID: config.orgAdminUser, enrollmentSecret: config.orgAdminPW});
+        // the chaincode only accepts provider-side transactions from certificates carrying viriot.role
+        const adminIdentity = {
+          credentials: {
+            certificate: bootstrapEnrollment.certificate,
+            privateKey: bootstrapEnrollment.key.toBytes(),
+          },
+          mspId: config.mspIdOrg,
+          type: 'X.509',
+        };
+        const provider = wallet!.getProviderRegistry().getProvider(adminIdentity.type);
+        const adminUser = await provider.getUserContext(adminIdentity, config.orgAdminUser);
+        await caClient.newIdentityService().update(config.orgAdminUser, {
+          attrs: [{name: "viriot.role", value: chaincodeRole("Admin"), ecert: true}],
+        }, adminUser);
         const enrollment = await caClient.enroll({ enrollmentID: config.orgAdminUser, enrollmentSecret: config.orgAdminPW});
         logger.debug('Creating Admin to mongodb');
         const hashedPW = await bcrypt.hash(config.orgAdminPW, 10);
@@ -52,6 +67,21 @@ export async function createAdmin(){
   });
 }
 
+/**
+ * Maps the role of a master-controller user to the viriot.role certificate
+ * attribute checked by the chaincode: admin, provider or consumer.
+ */
+export const chaincodeRole = (role: string): string => {
+  switch (role.toLowerCase()) {
+    case "admin":
+      return "admin";
+    case "provider":
+      return "provider";
+    default:
+      return "consumer";
+  }
+}
+
 export const getUserByID = async (userID: string) => {
   const user = await User.findOne({
     userID: userID,
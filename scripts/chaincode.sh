@@ -75,7 +75,7 @@ function deploy_chaincode() {
   local cc_package=${temp_folder}/${cc_name}.tgz
 
   prepare_chaincode_image ${cc_folder} ${cc_name}
-  package_chaincode       ${cc_name} ${cc_label} ${cc_package}
+  package_chaincode       ${cc_name} ${cc_label} ${cc_package} ${cc_folder}
 
   if [ "${CHAINCODE_BUILDER}" == "ccaas" ]; then
     set_chaincode_id      ${cc_package}
@@ -207,11 +207,24 @@ function package_chaincode() {
   fi
 }
 
+# Ship the CouchDB index definitions (META-INF/statedb/...) of the chaincode source
+# folder, if any, inside code.tar.gz so that the peers create them on install.
+function copy_chaincode_indexes() {
+  local cc_source=$1
+  local cc_folder=$2
+
+  if [ -z "${cc_source}" ] || [ ! -d "${cc_source}/META-INF" ]; then
+    return 1
+  fi
+  cp -R ${cc_source}/META-INF ${cc_folder}/
+}
+
 # The k8s builder expects EXACTLY an IMMUTABLE image digest referencing a SPECIFIC image layer at a container registry.
 function package_k8s_chaincode() {
   local cc_name=$1
   local cc_label=$2
   local cc_archive=$3
+  local cc_source=$4
 
   local cc_folder=$(dirname $cc_archive)
   local archive_name=$(basename $cc_archive)
@@ -237,7 +250,12 @@ IMAGEJSON-EOF
 }
 METADATAJSON-EOF
 
-  tar -C ${cc_folder} -zcf ${cc_folder}/code.tar.gz image.json
+  local code_files="image.json"
+  if copy_chaincode_indexes ${cc_source} ${cc_folder}; then
+    code_files="${code_files} META-INF"
+  fi
+
+  tar -C ${cc_folder} -zcf ${cc_folder}/code.tar.gz ${code_files}
   tar -C ${cc_folder} -zcf ${cc_archive} code.tar.gz metadata.json
 
   rm ${cc_folder}/code.tar.gz
@@ -249,6 +267,7 @@ function package_ccaas_chaincode() {
   local cc_name=$1
   local cc_label=$2
   local cc_archive=$3
+  local cc_source=$4
 
   local cc_folder=$(dirname $cc_archive)
   local archive_name=$(basename $cc_archive)
@@ -277,7 +296,12 @@ EOF
 }
 EOF
 
-  tar -C ${cc_folder} -zcf ${cc_folder}/code.tar.gz connection.json
+  local code_files="connection.json"
+  if copy_chaincode_indexes ${cc_source} ${cc_folder}; then
+    code_files="${code_files} META-INF"
+  fi
+
+  tar -C ${cc_folder} -zcf ${cc_folder}/code.tar.gz ${code_files}
   tar -C ${cc_folder} -zcf ${cc_archive} code.tar.gz metadata.json
 
   rm ${cc_folder}/code.tar.gz